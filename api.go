@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// APIToken is a bearer token that may use the API served by the serve command
+type APIToken struct {
+	Name     string   `yaml:"Name"`     // Shown in the log when the token is used
+	Token    string   `yaml:"Token"`    // Secret sent as "Authorization: Bearer <token>"
+	Backends []string `yaml:"Backends"` // Patterns of the backends the token may see and send actions to, or all if none
+	Actions  []string `yaml:"Actions"`  // Actions the token may send. A token without actions can only read.
+}
+
+// allowsAction returns true if the token may send the action
+func (t *APIToken) allowsAction(action haproxyctl.Action) bool {
+	for _, a := range t.Actions {
+		if haproxyctl.Action(strings.ToLower(a)) == action {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsBackend returns true if the token may send actions to servers in the backend. As HAProxy's names are
+// case-sensitive, an exact name only allows the backend of the same case, as it does in Resolve.
+func (t *APIToken) allowsBackend(backend string) bool {
+	for _, b := range t.Backends {
+		p, err := haproxyctl.ParsePattern(b)
+		if err != nil {
+			continue
+		}
+		if p.IsLiteral() && b == backend || !p.IsLiteral() && p.Match(backend) {
+			return true
+		}
+	}
+	return false
+}
+
+// seesBackend returns true if the token may see the statistics of the backend. A token without backends sees
+// all of them.
+func (t *APIToken) seesBackend(backend string) bool {
+	return len(t.Backends) == 0 || t.allowsBackend(backend)
+}
+
+// Limits on the requests made to the API
+const (
+	maxRequestBody    = 64 << 10
+	readHeaderTimeout = 10 * time.Second
+	writeTimeout      = 2 * time.Minute // Long enough to send an action to every load balancer in turn
+)
+
+// apiServer serves the JSON API over the configured load balancers
+type apiServer struct {
+	config *HAProxyCtlConfig
+}
+
+// actionRequest is the body of a POST to /api/v1/actions
+type actionRequest struct {
+	Action      string   `json:"action"`
+	Servers     []string `json:"servers"`
+	Backend     string   `json:"backend"`
+	AllBackends bool     `json:"all_backends"`
+	LB          string   `json:"lb"`     // Optional names or groups of the load balancers to send the action to
+	Select      string   `json:"select"` // Optional tags of the load balancers to send the action to
+}
+
+// runServe serves the JSON API until it is stopped
+func runServe(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandServe)
+	listen := flags.String("listen", ":8080", "Address to serve the API on")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if len(c.APITokens) == 0 {
+		return fmt.Errorf("serve needs at least one [[APITokens]] entry in the configuration file")
+	}
+	for _, t := range c.APITokens {
+		if t.Token == "" {
+			return fmt.Errorf("API token %v has no Token set", t.Name)
+		}
+		for _, b := range t.Backends {
+			if _, err := haproxyctl.ParsePattern(b); err != nil {
+				return fmt.Errorf("API token %v: %v", t.Name, err)
+			}
+		}
+		for _, a := range t.Actions {
+			if !haproxyctl.Action(strings.ToLower(a)).IsValid() {
+				return fmt.Errorf("API token %v: invalid action %v", t.Name, a)
+			}
+		}
+	}
+
+	s := &apiServer{config: c}
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+	}
+	log.Printf("Serving the API and dashboard for %v load balancers on %v", len(c.loadBalancers()), *listen)
+	return server.ListenAndServe()
+}
+
+// handler routes the API and the dashboard
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/loadbalancers", s.authenticated(s.serveLoadBalancers))
+	mux.HandleFunc("/api/v1/stats", s.authenticated(s.serveStats))
+	mux.HandleFunc("/api/v1/actions", s.authenticated(s.serveActions))
+	mux.HandleFunc("/api/v1/dashboard", s.authenticated(s.serveDashboardData))
+	mux.Handle("/", dashboardHandler())
+	return mux
+}
+
+// authenticated wraps a handler so that it is only called with a valid bearer token
+func (s *apiServer) authenticated(handler func(w http.ResponseWriter, r *http.Request, t *APIToken)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := s.token(r)
+		if t == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="haproxyctl"`)
+			apiError(w, http.StatusUnauthorized, fmt.Errorf("a valid bearer token is required"))
+			return
+		}
+		handler(w, r, t)
+	}
+}
+
+// token returns the API token the request was made with, or nil if it has none or it is not known
+func (s *apiServer) token(r *http.Request) *APIToken {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil
+	}
+	given := []byte(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	for i := range s.config.APITokens {
+		t := &s.config.APITokens[i]
+		if subtle.ConstantTimeCompare(given, []byte(t.Token)) == 1 {
+			return t
+		}
+	}
+	return nil
+}
+
+// selection returns the load balancers matching the request's lb and select parameters, which work like -lb
+// and -select, or all of them if it has neither
+func (s *apiServer) selection(lb, sel string) (*HAProxyCtlConfig, error) {
+	selector, err := parseLBSelector(lb, sel)
+	if err != nil || selector == nil {
+		return s.config, err
+	}
+	selected := s.config.selectLoadBalancers(selector)
+	if len(selected.LoadBalancers) == 0 {
+		return nil, fmt.Errorf("no load balancer matches %v", selector)
+	}
+	return selected, nil
+}
+
+var loadBalancerColumns = []column{
+	{Key: "name", Header: "Name", Value: func(r interface{}) interface{} { return r.(*LoadBalancer).Name }},
+	{Key: "url", Header: "URL", Value: func(r interface{}) interface{} { return redactURL(r.(*LoadBalancer).Url, false) }},
+	{Key: "groups", Header: "Groups", Value: func(r interface{}) interface{} { return r.(*LoadBalancer).Groups }},
+	{Key: "tags", Header: "Tags", Value: func(r interface{}) interface{} { return r.(*LoadBalancer).Tags }},
+}
+
+// serveLoadBalancers lists the configured load balancers, without their credentials
+func (s *apiServer) serveLoadBalancers(w http.ResponseWriter, r *http.Request, t *APIToken) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%v is not allowed", r.Method))
+		return
+	}
+	output := &report{Columns: loadBalancerColumns}
+	lbs := s.config.loadBalancers()
+	for i := range lbs {
+		output.Rows = append(output.Rows, &lbs[i])
+	}
+	writeJSONReport(w, http.StatusOK, output)
+}
+
+// serveStats gets the statistics of the load balancers, taking the same columns, where, sort and limit as get.
+// Only the backends the token may see are included.
+func (s *apiServer) serveStats(w http.ResponseWriter, r *http.Request, t *APIToken) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%v is not allowed", r.Method))
+		return
+	}
+	params := r.URL.Query()
+
+	c, err := s.selection(params.Get("lb"), params.Get("select"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	spec := params.Get("columns")
+	if spec == "" {
+		spec = "default"
+	}
+	columns, err := parseColumns(spec, false)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	query := &statQuery{Backend: t.seesBackend}
+	if where := params.Get("where"); where != "" {
+		if query.Where, err = haproxyctl.ParseFilter(where); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if sortSpec := params.Get("sort"); sortSpec != "" {
+		if query.Sort, err = haproxyctl.ParseSortKeys(sortSpec); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 0 {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %v", limit))
+			return
+		}
+	}
+
+	writeJSONReport(w, http.StatusOK, c.getDetails(columns, query, 0))
+}
+
+// serveActions sends an action to servers on every selected load balancer, if the token allows it. Patterns are
+// expanded first, and nothing is sent unless the token may act on every backend they expand to.
+func (s *apiServer) serveActions(w http.ResponseWriter, r *http.Request, t *APIToken) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%v is not allowed", r.Method))
+		return
+	}
+
+	var req actionRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+
+	action := haproxyctl.Action(strings.ToLower(req.Action))
+	if !action.IsValid() {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("invalid action %v", req.Action))
+		return
+	}
+	if len(req.Servers) == 0 {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("at least one server is required"))
+		return
+	}
+	if req.AllBackends {
+		req.Backend = haproxyctl.AllBackends
+	}
+	if req.Backend == "" {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("a backend, or all_backends, is required"))
+		return
+	}
+	if !t.allowsAction(action) {
+		apiError(w, http.StatusForbidden, fmt.Errorf("token %v may not send %v", t.Name, action))
+		return
+	}
+
+	c, err := s.selection(req.LB, req.Select)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	backend, err := haproxyctl.ParsePattern(req.Backend)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	servers, err := haproxyctl.ParsePatterns(req.Servers)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	plans := c.resolveTargets(backend, servers)
+	for _, p := range plans {
+		for _, target := range p.Targets {
+			if !t.allowsBackend(target.Backend) {
+				apiError(w, http.StatusForbidden, fmt.Errorf("token %v may not send actions to backend %v", t.Name, target.Backend))
+				return
+			}
+		}
+	}
+
+	for _, p := range plans {
+		for _, target := range p.Targets {
+			log.Printf("%v: %v %v: %v: %v", t.Name, action, p.LoadBalancer.Name, target.Backend, strings.Join(target.Servers, ", "))
+		}
+	}
+	writeJSONReport(w, http.StatusOK, executePlans(action, plans))
+}
+
+// writeJSONReport writes the report in the same JSON format as -output json
+func writeJSONReport(w http.ResponseWriter, status int, output *report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := output.Write(w, OutputJSON); err != nil {
+		log.Print(err)
+	}
+}
+
+// apiError writes an error as a JSON object
+func apiError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStats is the CSV served by fakeHAProxy, with a frontend, and servers in three backends
+const fakeStats = `# pxname,svname,status,type
+www,FRONTEND,OPEN,0
+prod-web,web01,UP,2
+prod-web,web02,UP,2
+prod-web,BACKEND,UP,1
+prod-api-1,api01,UP,2
+prod-api-1,web02,UP,2
+prod-api-1,BACKEND,UP,1
+staging,web01,UP,2
+staging,BACKEND,UP,1
+`
+
+// fakeHAProxy is a stats page that serves fakeStats, and records the actions POSTed to it
+type fakeHAProxy struct {
+	*httptest.Server
+	mu      sync.Mutex
+	actions []string
+}
+
+func newFakeHAProxy() *fakeHAProxy {
+	f := &fakeHAProxy{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, fakeStats)
+			return
+		}
+		//The form is sent without a Content-Type, so it is parsed here rather than by ParseForm
+		body, _ := ioutil.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		f.mu.Lock()
+		f.actions = append(f.actions, fmt.Sprintf("%v %v %v", form.Get("action"), form.Get("b"), strings.Join(form["s"], ",")))
+		f.mu.Unlock()
+		w.Header().Set("Location", "/haproxy;st=DONE")
+		w.WriteHeader(http.StatusSeeOther)
+	}))
+	return f
+}
+
+func (f *fakeHAProxy) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.actions...)
+}
+
+// testAPI returns the API over a fake load balancer, with a token limited to some backends, one with a
+// backend that differs only in case, and a read-only token
+func testAPI(t *testing.T) (*fakeHAProxy, http.Handler) {
+	t.Helper()
+	os.Setenv("HOME", t.TempDir())
+	f := newFakeHAProxy()
+	t.Cleanup(f.Close)
+
+	c := &HAProxyCtlConfig{
+		LoadBalancers: []LoadBalancer{{Name: "LB01", Url: f.URL + "/", Username: "admin", Password: "pw"}},
+		APITokens: []APIToken{
+			{Name: "portal", Token: "portal-token", Backends: []string{"prod-web", "prod-api-*"}, Actions: []string{"drain", "ready"}},
+			{Name: "shouty", Token: "shouty-token", Backends: []string{"PROD-WEB"}, Actions: []string{"drain"}},
+			{Name: "viewer", Token: "viewer-token"},
+		},
+	}
+	if errs := c.processInit().errors(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return f, (&apiServer{config: c}).handler()
+}
+
+func apiRequest(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	_, h := testAPI(t)
+	tests := []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong-token", http.StatusUnauthorized},
+		{"Bearer portal-token-and-more", http.StatusUnauthorized},
+		{"Basic cG9ydGFsLXRva2VuOg==", http.StatusUnauthorized},
+		{"Bearer portal-token", http.StatusOK},
+		{"Bearer viewer-token", http.StatusOK},
+	}
+	for _, path := range []string{"/api/v1/loadbalancers", "/api/v1/stats", "/api/v1/dashboard"} {
+		for _, tt := range tests {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%v %q: got %v, want %v", path, tt.auth, w.Code, tt.want)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%v %q: no WWW-Authenticate header", path, tt.auth)
+			}
+		}
+	}
+}
+
+func TestAPIActions(t *testing.T) {
+	tests := []struct {
+		token string
+		body  string
+		want  int
+		sent  []string
+	}{
+		{"portal-token", `{"action": "drain", "servers": ["web01"], "backend": "prod-web"}`, http.StatusOK, []string{"drain prod-web web01"}},
+		{"portal-token", `{"action": "ready", "servers": ["api*"], "backend": "prod-api-1"}`, http.StatusOK, []string{"ready prod-api-1 api01"}},
+		//Every backend that the patterns expand to must be allowed, or nothing is sent
+		{"portal-token", `{"action": "drain", "servers": ["web01"], "all_backends": true}`, http.StatusForbidden, nil},
+		{"portal-token", `{"action": "drain", "servers": ["web02"], "all_backends": true}`, http.StatusOK, []string{"drain prod-web web02", "drain prod-api-1 web02"}},
+		{"portal-token", `{"action": "maint", "servers": ["web01"], "backend": "prod-web"}`, http.StatusForbidden, nil},
+		{"portal-token", `{"action": "drain", "servers": ["web01"], "backend": "staging"}`, http.StatusForbidden, nil},
+		//An exact backend name in a token only allows the backend of the same case
+		{"shouty-token", `{"action": "drain", "servers": ["web01"], "backend": "prod-web"}`, http.StatusForbidden, nil},
+		{"viewer-token", `{"action": "drain", "servers": ["web01"], "backend": "prod-web"}`, http.StatusForbidden, nil},
+		{"", `{"action": "drain", "servers": ["web01"], "backend": "prod-web"}`, http.StatusUnauthorized, nil},
+		{"portal-token", `{"action": "drain", "servers": ["web01"], "backend": "prod-web", "lb": "` + strings.Repeat("x", maxRequestBody) + `"}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		f, h := testAPI(t)
+		w := apiRequest(h, http.MethodPost, "/api/v1/actions", tt.token, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%v %.80v: got %v, want %v: %v", tt.token, tt.body, w.Code, tt.want, w.Body)
+		}
+		if got := f.sent(); strings.Join(got, "; ") != strings.Join(tt.sent, "; ") {
+			t.Errorf("%v %.80v: sent %v, want %v", tt.token, tt.body, got, tt.sent)
+		}
+	}
+
+	//Other methods are not allowed
+	_, h := testAPI(t)
+	if w := apiRequest(h, http.MethodGet, "/api/v1/actions", "portal-token", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /api/v1/actions: got %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAPIStatsBackends(t *testing.T) {
+	_, h := testAPI(t)
+	tests := []struct {
+		token string
+		query string
+		want  []string
+	}{
+		{"portal-token", "", []string{"prod-api-1", "prod-web"}},
+		{"shouty-token", "", nil},
+		{"viewer-token", "", []string{"prod-api-1", "prod-web", "staging"}},
+		//Frontends and backends asked for with where are limited in the same way
+		{"portal-token", "?where=" + url.QueryEscape(`type != "server"`), []string{"prod-api-1", "prod-web"}},
+		{"viewer-token", "?where=" + url.QueryEscape(`type != "server"`), []string{"prod-api-1", "prod-web", "staging", "www"}},
+	}
+	for _, tt := range tests {
+		w := apiRequest(h, http.MethodGet, "/api/v1/stats"+tt.query, tt.token, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: got %v: %v", tt.token, w.Code, w.Body)
+		}
+		var rows []struct{ Pxname string }
+		if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]bool)
+		var got []string
+		for _, r := range rows {
+			if !seen[r.Pxname] {
+				seen[r.Pxname] = true
+				got = append(got, r.Pxname)
+			}
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%v%v: got backends %v, want %v", tt.token, tt.query, got, tt.want)
+		}
+	}
+
+	//The dashboard only has the backends the token may see
+	w := apiRequest(h, http.MethodGet, "/api/v1/dashboard", "portal-token", "")
+	var d struct{ Backends []struct{ Name string } }
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range d.Backends {
+		got = append(got, b.Name)
+	}
+	if strings.Join(got, ",") != "prod-api-1,prod-web" {
+		t.Errorf("dashboard: got backends %v, want prod-api-1,prod-web", got)
+	}
+}
@@ -0,0 +1,227 @@
+package haproxyctl
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// EventType is the kind of change an Event describes
+type EventType string
+
+const (
+	// EventServerDown is sent when a server goes DOWN
+	EventServerDown EventType = "server_down"
+	// EventServerUp is sent when a server comes UP, including when it leaves MAINT or DRAIN
+	EventServerUp EventType = "server_up"
+	// EventServerMaint is sent when a server enters maintenance
+	EventServerMaint EventType = "server_maint"
+	// EventServerDrain is sent when a server starts draining
+	EventServerDrain EventType = "server_drain"
+	// EventServerNoLB is sent when a server stops being load balanced, such as after hnolb
+	EventServerNoLB EventType = "server_nolb"
+	// EventCheckStatusChanged is sent when the result of a server's last health check changes, such as L7OK to L4CON
+	EventCheckStatusChanged EventType = "check_status_changed"
+	// EventBackendDown is sent when a backend no longer has any servers UP
+	EventBackendDown EventType = "backend_down"
+	// EventBackendUp is sent when a backend that had no servers UP has one again
+	EventBackendUp EventType = "backend_up"
+	// EventUnreachable is sent when the statistics of a HAProxy server cannot be retrieved
+	EventUnreachable EventType = "lb_unreachable"
+	// EventReachable is sent when the statistics of an unreachable HAProxy server can be retrieved again
+	EventReachable EventType = "lb_reachable"
+)
+
+// Event is a change in state found by comparing two snapshots of statistics
+type Event struct {
+	Time    time.Time `json:"time"`
+	LB      string    `json:"lb"` // Name of the HAProxy server, as given in the Fleet
+	Type    EventType `json:"type"`
+	Backend string    `json:"backend,omitempty"`
+	Server  string    `json:"server,omitempty"`
+	From    string    `json:"from,omitempty"` // Status before the change, such as "UP" or "L7OK"
+	To      string    `json:"to,omitempty"`   // Status after the change, or the error for EventUnreachable
+}
+
+// serverState reduces a HAProxy status such as "UP 1/3", "MAINT (via x/y)" or "no check" to UP, DOWN, MAINT,
+// DRAIN or NOLB. Servers that are going down or coming up keep their current state until the change is complete.
+func serverState(status string) string {
+	if status == "no check" {
+		return "UP"
+	}
+	return strings.SplitN(status, " ", 2)[0]
+}
+
+// CompareStatistics returns the events that explain the differences between two snapshots of statistics from
+// the same HAProxy server. Servers that are only in one of the snapshots are ignored.
+func CompareStatistics(lb string, previous, current *Statistics, at time.Time) []Event {
+	var events []Event
+
+	before := make(map[string]*Statistic)
+	for i := range *previous {
+		before[StatisticKey(&(*previous)[i])] = &(*previous)[i]
+	}
+
+	upBefore := serversUp(previous)
+	upNow := serversUp(current)
+
+	for i := range *current {
+		cur := &(*current)[i]
+		if cur.Type != Server {
+			continue
+		}
+		prev, ok := before[StatisticKey(cur)]
+		if !ok {
+			continue
+		}
+
+		event := Event{Time: at, LB: lb, Backend: cur.BackendName, Server: cur.FrontendName}
+		from, to := serverState(prev.Status), serverState(cur.Status)
+		if from != to {
+			event.From, event.To = prev.Status, cur.Status
+			switch to {
+			case "UP":
+				event.Type = EventServerUp
+			case "DOWN":
+				event.Type = EventServerDown
+			case "MAINT":
+				event.Type = EventServerMaint
+			case "DRAIN":
+				event.Type = EventServerDrain
+			case "NOLB":
+				event.Type = EventServerNoLB
+			}
+			if event.Type != "" {
+				events = append(events, event)
+			}
+		}
+
+		if prev.CheckStatus != cur.CheckStatus && prev.CheckStatus != "" && cur.CheckStatus != "" {
+			event.Type, event.From, event.To = EventCheckStatusChanged, prev.CheckStatus, cur.CheckStatus
+			events = append(events, event)
+		}
+	}
+
+	for _, backend := range current.Backends() {
+		was, ok := upBefore[backend]
+		if !ok {
+			continue
+		}
+		now := upNow[backend]
+		switch {
+		case was > 0 && now == 0:
+			events = append(events, Event{Time: at, LB: lb, Type: EventBackendDown, Backend: backend})
+		case was == 0 && now > 0:
+			events = append(events, Event{Time: at, LB: lb, Type: EventBackendUp, Backend: backend})
+		}
+	}
+
+	return events
+}
+
+// serversUp counts the servers that are UP in each backend that has servers
+func serversUp(s *Statistics) map[string]int {
+	up := make(map[string]int)
+	for _, x := range *s {
+		if x.Type != Server {
+			continue
+		}
+		if IsUp(x.Status) {
+			up[x.BackendName]++
+		} else if _, ok := up[x.BackendName]; !ok {
+			up[x.BackendName] = 0
+		}
+	}
+	return up
+}
+
+// Watch polls every member of the fleet each interval, and sends an Event on the returned channel for every
+// change in state between one poll and the next. The first poll only records the starting state. While a member
+// is unreachable, its last statistics are kept, so the events sent when it is reachable again cover everything
+// that changed in the meantime. The channel is closed once the context is done.
+func (f Fleet) Watch(ctx context.Context, interval time.Duration) <-chan Event {
+	return WatchFleet(ctx, interval, func() Fleet { return f })
+}
+
+// WatchFleet works as Fleet.Watch does, but gets the members of the fleet before each poll, so that members can be
+// added and removed while it runs. A member that is added only has its starting state recorded in its first
+// poll, and nothing is sent for a member that is removed.
+func WatchFleet(ctx context.Context, interval time.Duration, members func() Fleet) <-chan Event {
+	events := make(chan Event)
+
+	go func() {
+		defer close(events)
+
+		last := make(map[string]*Statistics)
+		unreachable := make(map[string]bool)
+		send := func(e Event) bool {
+			select {
+			case events <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			f := members()
+			current := make(map[string]bool)
+			for _, m := range f {
+				current[m.Name] = true
+			}
+			for name := range last {
+				if !current[name] {
+					delete(last, name)
+				}
+			}
+			for name := range unreachable {
+				if !current[name] {
+					delete(unreachable, name)
+				}
+			}
+
+			for _, fs := range f.GetStats() {
+				if fs.Err != nil {
+					if !unreachable[fs.Name] {
+						unreachable[fs.Name] = true
+						if !send(Event{Time: fs.Time, LB: fs.Name, Type: EventUnreachable, To: fs.Err.Error()}) {
+							return
+						}
+					}
+					continue
+				}
+
+				if unreachable[fs.Name] {
+					delete(unreachable, fs.Name)
+					if !send(Event{Time: fs.Time, LB: fs.Name, Type: EventReachable}) {
+						return
+					}
+				}
+				if previous, ok := last[fs.Name]; ok {
+					for _, e := range CompareStatistics(fs.Name, previous, fs.Stats, fs.Time) {
+						if !send(e) {
+							return
+						}
+					}
+				}
+				last[fs.Name] = fs.Stats
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events
+}
+
+// Watch polls the HAProxy server each interval, and sends an Event on the returned channel for every change in
+// state, as Fleet.Watch does. Events are named after the host of the HAProxy server's URL.
+func (c *HAProxyConfig) Watch(ctx context.Context, interval time.Duration) <-chan Event {
+	return Fleet{{Name: c.URL.Host, Config: c}}.Watch(ctx, interval)
+}
@@ -0,0 +1,112 @@
+package haproxyctl
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Unit describes what the number held in a Statistic field measures
+type Unit string
+
+const (
+	// UnitNone is used for names, statuses and plain counts
+	UnitNone Unit = ""
+	// UnitBytes is used for byte counters
+	UnitBytes Unit = "bytes"
+	// UnitSeconds is used for Duration fields
+	UnitSeconds Unit = "seconds"
+	// UnitMilliseconds is used for timings that HAProxy reports in milliseconds
+	UnitMilliseconds Unit = "milliseconds"
+)
+
+var fieldUnits = map[string]Unit{
+	"BytesIn":                 UnitBytes,
+	"BytesOut":                UnitBytes,
+	"CompressedBytesIn":       UnitBytes,
+	"CompressedBytesOut":      UnitBytes,
+	"CompressedBytesBypassed": UnitBytes,
+	"StatusLastChanged":       UnitSeconds,
+	"Downtime":                UnitSeconds,
+	"LastSession":             UnitSeconds,
+	"CheckDuration":           UnitMilliseconds,
+	"AvgQueueTime":            UnitMilliseconds,
+	"AvgConnectTime":          UnitMilliseconds,
+	"AvgResponseTime":         UnitMilliseconds,
+	"AvgTotalTime":            UnitMilliseconds,
+}
+
+// counterFields are the Statistic fields that only ever increase, until HAProxy is restarted or reloaded
+var counterFields = map[string]bool{
+	"SessionsTotal":           true,
+	"BytesIn":                 true,
+	"BytesOut":                true,
+	"DeniedRequests":          true,
+	"DeniedResponses":         true,
+	"ErrorsRequests":          true,
+	"ErrorsConnections":       true,
+	"ErrorsResponses":         true,
+	"WarningsRetries":         true,
+	"WarningsDispatches":      true,
+	"CheckFailed":             true,
+	"CheckDowned":             true,
+	"LBTotal":                 true,
+	"HTTPResponse1xx":         true,
+	"HTTPResponse2xx":         true,
+	"HTTPResponse3xx":         true,
+	"HTTPResponse4xx":         true,
+	"HTTPResponse5xx":         true,
+	"HTTPResponseOther":       true,
+	"CheckFailedDets":         true,
+	"RequestTotal":            true,
+	"AbortedByClient":         true,
+	"AbortedByServer":         true,
+	"CompressedBytesIn":       true,
+	"CompressedBytesOut":      true,
+	"CompressedBytesBypassed": true,
+	"CompressedResponses":     true,
+}
+
+// Field describes a single field of a Statistic
+type Field struct {
+	Name    string // Go field name, such as SessionsCurrent
+	Stat    string // HAProxy statistic name, such as scur
+	Unit    Unit
+	Counter bool // True if the field is a counter that only increases, such as stot
+	index   int
+}
+
+var statisticFields = func() []Field {
+	var fields []Field
+	t := reflect.TypeOf(Statistic{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fields = append(fields, Field{
+			Name:    f.Name,
+			Stat:    strings.TrimPrefix(f.Tag.Get("csv"), "# "),
+			Unit:    fieldUnits[f.Name],
+			Counter: counterFields[f.Name],
+			index:   i,
+		})
+	}
+	return fields
+}()
+
+// StatisticFields returns every field of a Statistic, in the order HAProxy reports them
+func StatisticFields() []Field {
+	return append([]Field(nil), statisticFields...)
+}
+
+// LookupField finds a Statistic field by either its Go name or its HAProxy statistic name, ignoring case
+func LookupField(name string) (Field, bool) {
+	for _, f := range statisticFields {
+		if strings.EqualFold(f.Name, name) || strings.EqualFold(f.Stat, name) {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Value returns the value of this field from a Statistic. The value is a string, uint64, Duration or EntryType.
+func (f Field) Value(s *Statistic) interface{} {
+	return reflect.ValueOf(s).Elem().Field(f.index).Interface()
+}
@@ -0,0 +1,452 @@
+package haproxyctl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter is a compiled filter expression, used as a predicate over statistics. Expressions compare Statistic
+// fields, named by their Go name or HAProxy statistic name, with literals. For example:
+//
+//	status != "UP" && scur > 50
+//	hrsp_5xx > 0 || (qcur > 0 && !(svname =~ "^backup"))
+//	type == "backend" && downtime > 5m
+//
+// The comparison operators are ==, !=, <, <=, >, >=, and =~ and !~ for regular expressions. These are combined
+// with &&, || and !, and grouped with parentheses. Strings are double-quoted. Numbers can have a duration
+// suffix (30s, 5m), which is converted to seconds, or to milliseconds when compared with a timing that HAProxy
+// reports in milliseconds, so rtime > 1s is rtime > 1000. Duration fields compare as seconds, and type compares
+// as one of "frontend", "backend", "server" or "socket". A field on its own is true if it is non-zero or
+// non-empty.
+type Filter struct {
+	expr   string
+	fields []Field
+	match  func(s *Statistic) bool
+}
+
+// ParseFilter compiles a filter expression
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %v in filter", p.peek())
+	}
+
+	return &Filter{expr: expr, fields: p.fields, match: match}, nil
+}
+
+// Match returns true if the statistic satisfies the filter
+func (f *Filter) Match(s *Statistic) bool {
+	return f.match(s)
+}
+
+// Fields returns the Statistic fields that the filter refers to
+func (f *Filter) Fields() []Field {
+	return append([]Field(nil), f.fields...)
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Where returns the statistics that satisfy the filter
+func (s *Statistics) Where(f *Filter) *Statistics {
+	var matched Statistics
+	for i := range *s {
+		if f.Match(&(*s)[i]) {
+			matched = append(matched, (*s)[i])
+		}
+	}
+	return &matched
+}
+
+// SortKey is a Statistic field to sort by, and the direction to sort in
+type SortKey struct {
+	Field      Field
+	Descending bool
+}
+
+// SortKeys is an ordered list of fields to sort statistics by
+type SortKeys []SortKey
+
+// ParseSortKeys parses a comma-separated list of fields to sort by. A field prefixed with "-" is sorted in
+// descending order, for example "-scur,svname".
+func ParseSortKeys(spec string) (SortKeys, error) {
+	var keys SortKeys
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		descending := strings.HasPrefix(name, "-")
+		f, ok := LookupField(strings.TrimPrefix(name, "-"))
+		if !ok {
+			return nil, fmt.Errorf("unknown sort field %v", strings.TrimPrefix(name, "-"))
+		}
+		keys = append(keys, SortKey{Field: f, Descending: descending})
+	}
+	return keys, nil
+}
+
+// Less reports whether statistic a sorts before statistic b
+func (k SortKeys) Less(a, b *Statistic) bool {
+	for _, key := range k {
+		c := compareValues(comparableValue(key.Field.Value(a)), comparableValue(key.Field.Value(b)))
+		if c == 0 {
+			continue
+		}
+		if key.Descending {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// Sort sorts the statistics in place by the given keys. Statistics that are equal keep their order.
+func (s *Statistics) Sort(keys SortKeys) {
+	sort.SliceStable(*s, func(i, j int) bool {
+		return keys.Less(&(*s)[i], &(*s)[j])
+	})
+}
+
+// comparableValue converts a Statistic field value into a float64 or string for comparison
+func comparableValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case uint64:
+		return float64(x)
+	case Duration:
+		return x.Seconds()
+	case EntryType:
+		return x.String()
+	}
+	return v
+}
+
+func compareValues(a, b interface{}) int {
+	switch x := a.(type) {
+	case float64:
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	}
+	return 0
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+)
+
+type filterToken struct {
+	kind     tokenKind
+	text     string
+	value    interface{}
+	duration bool // A number with a duration suffix, whose value is in seconds
+}
+
+func (t filterToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+var filterOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!"}
+
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	r := []rune(expr)
+
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '(':
+			tokens = append(tokens, filterToken{kind: tokenLParen, text: "("})
+			i++
+
+		case c == ')':
+			tokens = append(tokens, filterToken{kind: tokenRParen, text: ")"})
+			i++
+
+		case c == '"':
+			j := i + 1
+			for j < len(r) && r[j] != '"' {
+				if r[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(r) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			text := string(r[i : j+1])
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %v in filter", text)
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: text, value: value})
+			i = j + 1
+
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(r) && (unicode.IsDigit(r[j]) || unicode.IsLetter(r[j]) || r[j] == '.') {
+				j++
+			}
+			text := string(r[i:j])
+			value, err := strconv.ParseFloat(text, 64)
+			duration := false
+			if err != nil {
+				d, derr := time.ParseDuration(text)
+				if derr != nil {
+					return nil, fmt.Errorf("invalid number %v in filter", text)
+				}
+				value, duration = d.Seconds(), true
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: text, value: value, duration: duration})
+			i = j
+
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: string(r[i:j])})
+			i = j
+
+		default:
+			matched := false
+			for _, op := range filterOps {
+				if strings.HasPrefix(string(r[i:]), op) {
+					tokens = append(tokens, filterToken{kind: tokenOp, text: op})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q in filter", c)
+			}
+		}
+	}
+
+	return append(tokens, filterToken{kind: tokenEOF}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	fields []Field
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *filterParser) acceptOp(op string) bool {
+	if t := p.peek(); t.kind == tokenOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (func(s *Statistic) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(s *Statistic) bool { return l(s) || right(s) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (func(s *Statistic) bool, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(s *Statistic) bool { return l(s) && right(s) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (func(s *Statistic) bool, error) {
+	if p.acceptOp("!") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(s *Statistic) bool { return !inner(s) }, nil
+	}
+
+	if p.peek().kind == tokenLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, fmt.Errorf("expected \")\" but found %v in filter", t)
+		}
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+// operand is a field or literal on one side of a comparison
+type operand struct {
+	value    func(s *Statistic) interface{}
+	isString bool
+	literal  *filterToken
+	unit     Unit // Unit of the field, if the operand is one
+}
+
+func (p *filterParser) parseOperand() (*operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenIdent:
+		f, ok := LookupField(t.text)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %v in filter", t.text)
+		}
+		p.fields = append(p.fields, f)
+		_, isString := comparableValue(f.Value(&Statistic{})).(string)
+		return &operand{
+			value:    func(s *Statistic) interface{} { return comparableValue(f.Value(s)) },
+			isString: isString,
+			unit:     f.Unit,
+		}, nil
+	case tokenNumber, tokenString:
+		value := t.value
+		return &operand{
+			value:    func(s *Statistic) interface{} { return value },
+			isString: t.kind == tokenString,
+			literal:  &t,
+		}, nil
+	}
+	return nil, fmt.Errorf("expected a field or value but found %v in filter", t)
+}
+
+// scaleTo converts a duration literal, which is in seconds, into milliseconds when it is compared with a field
+// that HAProxy reports in milliseconds
+func (o *operand) scaleTo(other *operand) {
+	if o.literal == nil || !o.literal.duration || other.unit != UnitMilliseconds {
+		return
+	}
+	value := o.literal.value.(float64) * 1000
+	o.value = func(s *Statistic) interface{} { return value }
+}
+
+func (p *filterParser) parseComparison() (func(s *Statistic) bool, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if t.kind != tokenOp || t.text == "&&" || t.text == "||" || t.text == "!" {
+		//A field on its own is tested for being non-zero or non-empty
+		if left.literal != nil {
+			return nil, fmt.Errorf("expected a comparison after %v in filter", left.literal)
+		}
+		if left.isString {
+			return func(s *Statistic) bool { return left.value(s).(string) != "" }, nil
+		}
+		return func(s *Statistic) bool { return left.value(s).(float64) != 0 }, nil
+	}
+	op := p.next().text
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if op == "=~" || op == "!~" {
+		if !left.isString || right.literal == nil || !right.isString {
+			return nil, fmt.Errorf("%v must compare a text field with a quoted regular expression", op)
+		}
+		re, err := regexp.Compile(right.literal.value.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %v in filter: %v", right.literal, err)
+		}
+		negate := op == "!~"
+		return func(s *Statistic) bool { return re.MatchString(left.value(s).(string)) != negate }, nil
+	}
+
+	if left.isString != right.isString {
+		return nil, fmt.Errorf("cannot compare text with a number using %v in filter", op)
+	}
+	left.scaleTo(right)
+	right.scaleTo(left)
+
+	return func(s *Statistic) bool {
+		c := compareValues(left.value(s), right.value(s))
+		switch op {
+		case "==":
+			return c == 0
+		case "!=":
+			return c != 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		case ">=":
+			return c >= 0
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,141 @@
+package haproxyctl
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFilterStat() *Statistic {
+	return &Statistic{
+		Type:            Server,
+		BackendName:     "prod-web",
+		FrontendName:    "backup-web01",
+		Status:          "DOWN",
+		SessionsCurrent: 60,
+		QueueCurrent:    0,
+		HTTPResponse5xx: 3,
+		Downtime:        Duration{10 * time.Minute},
+		AvgResponseTime: 20,
+		CheckDuration:   1500,
+	}
+}
+
+func TestFilterPrecedence(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		//&& binds tighter than ||, so these are true || (false && false) and (false && false) || true
+		{`scur > 50 || qcur > 0 && hrsp_5xx > 10`, true},
+		{`qcur > 0 && hrsp_5xx > 10 || scur > 50`, true},
+		{`(scur > 50 || qcur > 0) && hrsp_5xx > 10`, false},
+		//! negates the comparison after it, and binds tighter than && and ||
+		{`!qcur > 0 && scur > 50`, true},
+		{`!scur > 50 || hrsp_5xx > 0`, true},
+		{`!(scur > 50 || hrsp_5xx > 0)`, false},
+		{`!qcur && scur > 50`, true},
+		{`!!hrsp_5xx`, true},
+		{`status != "UP" && (hrsp_5xx > 0 || (qcur > 0 && !(svname =~ "^backup")))`, true},
+		{`type == "server" && downtime > 5m`, true},
+		{`type == "backend" || downtime >= 600`, true},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("%v: %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(testFilterStat()); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestFilterQuoting(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`pxname == "prod-web"`, true},
+		{`pxname == "prod-web "`, false},
+		{`svname == "backup-web01" && status == "DOWN"`, true},
+		//Operators and parentheses inside quotes are part of the string
+		{`svname != "a && b || (c)"`, true},
+		{`svname =~ "^backup-web0[1-9]$"`, true},
+		{`svname !~ "\\d$"`, false},
+		{`status == "DO\"WN"`, false},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("%v: %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(testFilterStat()); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestFilterUnits(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		//Durations compare in seconds with Duration fields
+		{`downtime > 5m`, true},
+		{`downtime > 10m`, false},
+		{`downtime == 600`, true},
+		//and in milliseconds with fields HAProxy reports in milliseconds, which plain numbers are compared in
+		{`rtime > 10s`, false},
+		{`rtime < 1s`, true},
+		{`rtime > 10ms && rtime < 30ms`, true},
+		{`rtime == 20`, true},
+		{`10s > rtime`, true},
+		{`check_duration > 1s && check_duration < 2s`, true},
+		{`scur > 1m`, false},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("%v: %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(testFilterStat()); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{`status == "UP`, "unterminated string"},
+		{`nosuchfield > 1`, "unknown field nosuchfield"},
+		{`scur > `, "expected a field or value"},
+		{`(scur > 1`, `expected ")"`},
+		{`scur > 1)`, `unexpected ")"`},
+		{`scur > 1 status`, `unexpected "status"`},
+		{`scur @ 1`, `unexpected '@'`},
+		{`12abc > 1`, "invalid number 12abc"},
+		{`"UP"`, "expected a comparison"},
+		{`status == 1`, "cannot compare text with a number"},
+		{`scur =~ "1"`, "must compare a text field"},
+		{`status =~ status`, "must compare a text field"},
+		{`status =~ "("`, "invalid regular expression"},
+		{``, "expected a field or value but found end of expression"},
+	}
+	for _, tt := range tests {
+		_, err := ParseFilter(tt.expr)
+		if err == nil {
+			t.Errorf("%v: parsed, want an error containing %v", tt.expr, tt.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: got %v, want an error containing %v", tt.expr, err, tt.err)
+		}
+	}
+}
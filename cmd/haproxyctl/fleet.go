@@ -0,0 +1,47 @@
+package haproxyctl
+
+import (
+	"sync"
+	"time"
+)
+
+// Fleet is a set of HAProxy servers that are managed together, such as a pair of load balancers that share the
+// same backends
+type Fleet []FleetMember
+
+// FleetMember is a single, named HAProxy server in a Fleet
+type FleetMember struct {
+	Name   string
+	Config *HAProxyConfig
+}
+
+// FleetStats is the statistics from one member of a Fleet, or the error from retrieving them
+type FleetStats struct {
+	Name  string
+	Stats *Statistics
+	Raw   []byte // The CSV the statistics were parsed from
+	Err   error
+	Time  time.Time // When the statistics were received
+}
+
+// GetStats gets the latest statistics from every member of the fleet at once. The results are in the same order
+// as the members of the fleet.
+func (f Fleet) GetStats() []FleetStats {
+	results := make([]FleetStats, len(f))
+	var wg sync.WaitGroup
+	for i, m := range f {
+		wg.Add(1)
+		go func(i int, m FleetMember) {
+			defer wg.Done()
+			result := FleetStats{Name: m.Name}
+			result.Raw, result.Err = m.Config.GetRawStats()
+			if result.Err == nil {
+				result.Stats, result.Err = ParseStatistics(result.Raw)
+			}
+			result.Time = time.Now()
+			results[i] = result
+		}(i, m)
+	}
+	wg.Wait()
+	return results
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/gocarina/gocsv"
@@ -14,6 +15,16 @@ import (
 
 // GetStats gets the latest set of statistics from HAProxy
 func (c *HAProxyConfig) GetStats() (*Statistics, error) {
+	csvBody, err := c.GetRawStats()
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatistics(csvBody)
+}
+
+// GetRawStats gets the latest set of statistics from HAProxy as the CSV it was sent in, for keeping or
+// parsing later with ParseStatistics
+func (c *HAProxyConfig) GetRawStats() ([]byte, error) {
 	req, err := http.NewRequest("GET", c.GetRequestURI(true), nil)
 	if err != nil {
 		return nil, err
@@ -26,18 +37,50 @@ func (c *HAProxyConfig) GetStats() (*Statistics, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("status code %v", resp.StatusCode)
 	}
 
-	csvBody, err := ioutil.ReadAll(resp.Body)
+	return ioutil.ReadAll(resp.Body)
+}
+
+// adminForm finds the form that HAProxy only puts on its stats page when actions can be sent
+var adminForm = regexp.MustCompile(`(?i)<form[^>]*method="?post`)
+
+// IsAdmin returns true if actions can be sent to HAProxy, which needs "stats admin" in its configuration. It looks
+// for the form HAProxy puts on its stats page in admin mode.
+func (c *HAProxyConfig) IsAdmin() (bool, error) {
+	req, err := http.NewRequest("GET", c.GetRequestURI(false), nil)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
 	}
 
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("status code %v", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	return adminForm.Match(body), nil
+}
+
+// ParseStatistics parses statistics in the CSV format HAProxy sends them in
+func ParseStatistics(csvBody []byte) (*Statistics, error) {
 	var theseStats Statistics
-	err = gocsv.UnmarshalBytes(csvBody, &theseStats)
+	err := gocsv.UnmarshalBytes(csvBody, &theseStats)
 	if err != nil {
 		return nil, err
 	}
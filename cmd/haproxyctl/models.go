@@ -4,27 +4,43 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 )
 
+// DefaultTimeout is how long a request to HAProxy can take when HAProxyConfig has no Timeout
+const DefaultTimeout = 10 * time.Second
+
 // HAProxyConfig holds the basic configuration options for haproxyctl
 type HAProxyConfig struct {
 	URL       url.URL
 	Username  string
 	Password  string
+	Timeout   time.Duration // How long a request can take, including reading the response, or DefaultTimeout
 	client    *http.Client
 	setupdone bool
 }
 
+// setupLock guards the lazy creation of each HAProxyConfig's HTTP client, so that one configuration can be used
+// from several goroutines at once
+var setupLock sync.Mutex
+
 func (c *HAProxyConfig) setupClient() {
+	setupLock.Lock()
+	defer setupLock.Unlock()
 	if c.setupdone {
 		return
 	}
 
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
 	c.client = &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
+		Timeout: timeout,
 	}
 	c.setupdone = true
 }
@@ -135,6 +151,20 @@ const (
 	Socket
 )
 
+func (t EntryType) String() string {
+	switch t {
+	case Frontend:
+		return "frontend"
+	case Backend:
+		return "backend"
+	case Server:
+		return "server"
+	case Socket:
+		return "socket"
+	}
+	return fmt.Sprintf("EntryType(%d)", int(t))
+}
+
 // Action is a set of actions that we can send to a HAProxy server
 type Action string
 
@@ -153,3 +183,30 @@ const (
 	ActionAgentForceDown      Action = "adown"
 	ActionKillSessions        Action = "shutdown"
 )
+
+// Actions lists every Action that can be sent to HAProxy
+var Actions = []Action{
+	ActionSetStateToReady,
+	ActionSetStateToDrain,
+	ActionSetStateToMaint,
+	ActionHealthDisableChecks,
+	ActionHealthEnableChecks,
+	ActionHealthForceUp,
+	ActionHealthForceNoLB,
+	ActionHealthForceDown,
+	ActionAgentDisablechecks,
+	ActionAgentEnablechecks,
+	ActionAgentForceUp,
+	ActionAgentForceDown,
+	ActionKillSessions,
+}
+
+// IsValid returns true if the action is one of the Actions that HAProxy accepts
+func (a Action) IsValid() bool {
+	for _, x := range Actions {
+		if x == a {
+			return true
+		}
+	}
+	return false
+}
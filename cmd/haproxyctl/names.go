@@ -0,0 +1,157 @@
+package haproxyctl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// UnknownNameError is returned when a backend or server name does not exist on a HAProxy server
+type UnknownNameError struct {
+	Kind        string // "backend" or "server"
+	Name        string
+	Backend     string // the backend searched, when Kind is "server"
+	Suggestions []string
+}
+
+func (e *UnknownNameError) Error() string {
+	msg := fmt.Sprintf("unknown %v %v", e.Kind, e.Name)
+	if e.Backend != "" {
+		msg = fmt.Sprintf("%v in backend %v", msg, e.Backend)
+	}
+	if len(e.Suggestions) > 0 {
+		msg = fmt.Sprintf("%v (did you mean %v?)", msg, strings.Join(e.Suggestions, ", "))
+	}
+	return msg
+}
+
+// AmbiguousNameError is returned when an exact name is not in HAProxy's case, and more than one name differs
+// from it only in case. HAProxy's names are case-sensitive, so the name has to be given in the case meant.
+type AmbiguousNameError struct {
+	Kind    string // "backend" or "server"
+	Name    string
+	Backend string // the backend searched, when Kind is "server"
+	Matches []string
+}
+
+func (e *AmbiguousNameError) Error() string {
+	msg := fmt.Sprintf("%v %v is ambiguous", e.Kind, e.Name)
+	if e.Backend != "" {
+		msg = fmt.Sprintf("%v in backend %v", msg, e.Backend)
+	}
+	return fmt.Sprintf("%v, it could be any of %v (give the name in the case HAProxy has it)", msg, strings.Join(e.Matches, ", "))
+}
+
+// NameErrors is a list of backend or server names that could not be resolved
+type NameErrors []*UnknownNameError
+
+func (e NameErrors) Error() string {
+	var msgs []string
+	for _, x := range e {
+		msgs = append(msgs, x.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Backends returns the names of all of the backends, including those without servers, in the order HAProxy
+// reports them
+func (s *Statistics) Backends() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, x := range *s {
+		if x.Type != Server && x.Type != Backend || seen[x.BackendName] {
+			continue
+		}
+		seen[x.BackendName] = true
+		names = append(names, x.BackendName)
+	}
+	return names
+}
+
+// Servers returns the names of all of the servers in the given backend. If backend is blank, the servers
+// in every backend are returned, without duplicates.
+func (s *Statistics) Servers(backend string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, x := range *s {
+		if x.Type != Server || seen[x.FrontendName] {
+			continue
+		}
+		if backend != "" && x.BackendName != backend {
+			continue
+		}
+		seen[x.FrontendName] = true
+		names = append(names, x.FrontendName)
+	}
+	return names
+}
+
+// maxSuggestions is the most "did you mean" suggestions offered for an unknown name
+const maxSuggestions = 3
+
+// suggest returns the candidates that are close to name, closest first
+func suggest(name string, candidates []string) []string {
+	type scored struct {
+		name     string
+		distance int
+	}
+
+	lname := strings.ToLower(name)
+	limit := len(lname) / 3
+	if limit < 2 {
+		limit = 2
+	}
+
+	var matches []scored
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		lc := strings.ToLower(c)
+		d := levenshtein(lname, lc)
+		if d <= limit || (len(lname) > 2 && strings.Contains(lc, lname)) {
+			matches = append(matches, scored{c, d})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+
+	var names []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		names = append(names, matches[i].name)
+	}
+	return names
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,66 @@
+package haproxyctl
+
+import (
+	"time"
+)
+
+// Rates holds the per-second rate of each counter of a single frontend, backend or server, worked out from two
+// snapshots of statistics
+type Rates struct {
+	BackendName  string
+	FrontendName string
+	Type         EntryType
+	PerSecond    map[string]float64 // Keyed by the Go name of each counter field, such as BytesIn
+	Reset        bool               // True if any counter went backwards, as happens when HAProxy is reloaded
+}
+
+// StatisticKey identifies a frontend, backend or server across snapshots of statistics
+func StatisticKey(s *Statistic) string {
+	return s.BackendName + "/" + s.FrontendName
+}
+
+// ComputeRates compares two snapshots of statistics, taken elapsed apart, and returns the per-second rate of
+// every counter field for each proxy and server in the current snapshot. Entries that are not in the previous
+// snapshot are left out. If a counter has gone backwards, HAProxy is assumed to have been reloaded and the counter
+// to have started again from zero, so its current value is used as the increase.
+func ComputeRates(previous, current *Statistics, elapsed time.Duration) []Rates {
+	if elapsed <= 0 {
+		return nil
+	}
+
+	before := make(map[string]*Statistic)
+	for i := range *previous {
+		before[StatisticKey(&(*previous)[i])] = &(*previous)[i]
+	}
+
+	var rates []Rates
+	for i := range *current {
+		cur := &(*current)[i]
+		prev, ok := before[StatisticKey(cur)]
+		if !ok {
+			continue
+		}
+
+		r := Rates{
+			BackendName:  cur.BackendName,
+			FrontendName: cur.FrontendName,
+			Type:         cur.Type,
+			PerSecond:    make(map[string]float64),
+		}
+		for _, f := range statisticFields {
+			if !f.Counter {
+				continue
+			}
+			now, then := f.Value(cur).(uint64), f.Value(prev).(uint64)
+			delta := now - then
+			if now < then {
+				delta = now
+				r.Reset = true
+			}
+			r.PerSecond[f.Name] = float64(delta) / elapsed.Seconds()
+		}
+		rates = append(rates, r)
+	}
+
+	return rates
+}
@@ -0,0 +1,89 @@
+package haproxyctl
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// jsonStat is a single field of a single proxy or server, in the format of HAProxy's "show stat json"
+type jsonStat struct {
+	Field struct {
+		Pos  int    `json:"pos"`
+		Name string `json:"name"`
+	} `json:"field"`
+	Value struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	} `json:"value"`
+}
+
+// ParseStatisticsJSON parses statistics in the JSON format of HAProxy's "show stat json" command. They are
+// converted into HAProxy's CSV format and parsed with ParseStatistics, so both give the same results.
+func ParseStatisticsJSON(jsonBody []byte) (*Statistics, error) {
+	var records [][]jsonStat
+	if err := json.Unmarshal(jsonBody, &records); err != nil {
+		return nil, fmt.Errorf("not in the format of show stat json: %v", err)
+	}
+
+	//Work out the columns from every field seen, in HAProxy's order
+	positions := make(map[string]int)
+	for _, record := range records {
+		for _, s := range record {
+			positions[s.Field.Name] = s.Field.Pos
+		}
+	}
+	var names []string
+	for name := range positions {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return positions[names[i]] < positions[names[j]] })
+
+	var buf bytes.Buffer
+	buf.WriteString("# " + strings.Join(names, ",") + "\n")
+	w := csv.NewWriter(&buf)
+	for _, record := range records {
+		values := make(map[string]string)
+		for _, s := range record {
+			values[s.Field.Name] = jsonStatValue(s.Value.Value)
+		}
+		row := make([]string, len(names))
+		for i, name := range names {
+			row[i] = values[name]
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+
+	return ParseStatistics(buf.Bytes())
+}
+
+// jsonStatValue turns the value of a "show stat json" field into the text HAProxy would have put in its CSV
+func jsonStatValue(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n.String()
+	}
+	return strings.Trim(string(raw), `"`)
+}
+
+// DecodeStatistics parses statistics in either HAProxy's CSV format, as used by GetStats, or the JSON format of
+// "show stat json"
+func DecodeStatistics(body []byte) (*Statistics, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		return ParseStatisticsJSON(body)
+	}
+	return ParseStatistics(body)
+}
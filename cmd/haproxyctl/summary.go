@@ -0,0 +1,133 @@
+package haproxyctl
+
+import (
+	"strings"
+)
+
+// BackendState is the overall health of a backend
+type BackendState string
+
+const (
+	// BackendHealthy indicates every server is up on every HAProxy server
+	BackendHealthy BackendState = "healthy"
+	// BackendDegraded indicates some servers are not up, but every HAProxy server still has at least one up
+	BackendDegraded BackendState = "degraded"
+	// BackendDown indicates at least one HAProxy server has servers in the backend, but none of them up
+	BackendDown BackendState = "down"
+	// BackendEmpty indicates the backend has no servers on any HAProxy server, such as a listen stats section
+	BackendEmpty BackendState = "empty"
+)
+
+// BackendHealth is the health of a backend on a single HAProxy server
+type BackendHealth struct {
+	Name            string // Name of the HAProxy server, as given in the Fleet
+	Status          string // Status of the backend itself, as reported by HAProxy
+	ServersUp       int
+	ServersTotal    int
+	SessionsCurrent uint64
+	QueueCurrent    uint64
+	HTTPResponse5xx uint64
+}
+
+// BackendSummary is the health of a backend rolled up across every HAProxy server it is found on
+type BackendSummary struct {
+	Backend         string
+	State           BackendState
+	LoadBalancers   []BackendHealth
+	ServersUp       int
+	ServersTotal    int
+	SessionsCurrent uint64
+	QueueCurrent    uint64
+	HTTPResponse5xx uint64
+}
+
+// IsUp returns true if a server's status means it is taking new traffic. Servers without health checks are
+// counted as up, but draining servers are not.
+func IsUp(status string) bool {
+	return strings.HasPrefix(status, "UP") || status == "no check"
+}
+
+// Summarize rolls up the servers of each backend into the health of the backend on each HAProxy server, and
+// across all of them. Sessions, queues and 5xx responses are taken from HAProxy's own backend totals. Members of
+// the fleet whose statistics could not be retrieved are left out. Backends are returned in the order they are
+// first seen.
+func Summarize(stats []FleetStats) []BackendSummary {
+	var summaries []BackendSummary
+	index := make(map[string]int)
+
+	for _, fs := range stats {
+		if fs.Err != nil || fs.Stats == nil {
+			continue
+		}
+
+		health := make(map[string]*BackendHealth)
+		var order []string
+		get := func(backend string) *BackendHealth {
+			h, ok := health[backend]
+			if !ok {
+				h = &BackendHealth{Name: fs.Name}
+				health[backend] = h
+				order = append(order, backend)
+			}
+			return h
+		}
+
+		for _, s := range *fs.Stats {
+			switch s.Type {
+			case Server:
+				h := get(s.BackendName)
+				h.ServersTotal++
+				if IsUp(s.Status) {
+					h.ServersUp++
+				}
+			case Backend:
+				h := get(s.BackendName)
+				h.Status = s.Status
+				h.SessionsCurrent = s.SessionsCurrent
+				h.QueueCurrent = s.QueueCurrent
+				h.HTTPResponse5xx = s.HTTPResponse5xx
+			}
+		}
+
+		for _, backend := range order {
+			i, ok := index[backend]
+			if !ok {
+				i = len(summaries)
+				index[backend] = i
+				summaries = append(summaries, BackendSummary{Backend: backend})
+			}
+			h := health[backend]
+			sum := &summaries[i]
+			sum.LoadBalancers = append(sum.LoadBalancers, *h)
+			sum.ServersUp += h.ServersUp
+			sum.ServersTotal += h.ServersTotal
+			sum.SessionsCurrent += h.SessionsCurrent
+			sum.QueueCurrent += h.QueueCurrent
+			sum.HTTPResponse5xx += h.HTTPResponse5xx
+		}
+	}
+
+	for i := range summaries {
+		summaries[i].State = backendState(summaries[i].LoadBalancers)
+	}
+	return summaries
+}
+
+func backendState(health []BackendHealth) BackendState {
+	state := BackendEmpty
+	for _, h := range health {
+		if h.ServersTotal == 0 {
+			continue
+		}
+		if h.ServersUp == 0 {
+			return BackendDown
+		}
+		if state == BackendEmpty {
+			state = BackendHealthy
+		}
+		if h.ServersUp < h.ServersTotal {
+			state = BackendDegraded
+		}
+	}
+	return state
+}
@@ -0,0 +1,22 @@
+package haproxyctl
+
+import "testing"
+
+func TestBackendState(t *testing.T) {
+	tests := []struct {
+		health []BackendHealth
+		want   BackendState
+	}{
+		{[]BackendHealth{{ServersUp: 2, ServersTotal: 2}, {ServersUp: 2, ServersTotal: 2}}, BackendHealthy},
+		{[]BackendHealth{{ServersUp: 2, ServersTotal: 2}, {ServersUp: 1, ServersTotal: 2}}, BackendDegraded},
+		{[]BackendHealth{{ServersUp: 2, ServersTotal: 2}, {ServersUp: 0, ServersTotal: 2}}, BackendDown},
+		//A listen stats section has no servers, which is not the same as having none up
+		{[]BackendHealth{{ServersUp: 0, ServersTotal: 0}, {ServersUp: 0, ServersTotal: 0}}, BackendEmpty},
+		{[]BackendHealth{{ServersUp: 0, ServersTotal: 0}, {ServersUp: 1, ServersTotal: 1}}, BackendHealthy},
+	}
+	for i, tt := range tests {
+		if got := backendState(tt.health); got != tt.want {
+			t.Errorf("%v: got %v, want %v", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package haproxyctl
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// AllBackends is a backend pattern that matches every backend
+const AllBackends = "*"
+
+// Pattern matches backend or server names. A pattern can be an exact name, a glob such as "ny-web*"
+// (using the syntax of path.Match), or a regular expression wrapped in slashes such as "/^prod-(web|api)$/".
+// Exact names and globs match regardless of case, as the names are resolved back to the case HAProxy uses, and an
+// exact name in HAProxy's case only matches that name (see Resolve). Regular expressions are matched as written; use (?i) for a case-insensitive expression.
+type Pattern struct {
+	raw  string
+	glob bool
+	re   *regexp.Regexp
+}
+
+// ParsePattern parses a single name, glob or regular expression into a Pattern
+func ParsePattern(s string) (*Pattern, error) {
+	p := &Pattern{raw: s}
+
+	if len(s) > 1 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %v: %v", s, err)
+		}
+		p.re = re
+		return p, nil
+	}
+
+	if strings.ContainsAny(s, "*?[") {
+		if _, err := path.Match(s, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %v: %v", s, err)
+		}
+		p.glob = true
+	}
+
+	return p, nil
+}
+
+// ParsePatterns parses a list of names, globs or regular expressions
+func ParsePatterns(s []string) ([]*Pattern, error) {
+	var patterns []*Pattern
+	for _, x := range s {
+		p, err := ParsePattern(x)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// SplitPatterns splits a comma-separated list of names, globs or regular expressions. Commas inside a regular
+// expression, such as /^web0{1,2}$/, are kept, as it runs until the next / that ends the list or is followed by a
+// comma.
+func SplitPatterns(s string) []string {
+	var list []string
+	for s != "" {
+		end := strings.Index(s, ",")
+		if strings.HasPrefix(s, "/") {
+			for i := 1; i < len(s); i++ {
+				if s[i] == '/' && (i == len(s)-1 || s[i+1] == ',') {
+					end = i + 1
+					break
+				}
+			}
+		}
+		if end < 0 || end >= len(s) {
+			list = append(list, s)
+			break
+		}
+		list = append(list, s[:end])
+		s = s[end+1:]
+	}
+	return list
+}
+
+// IsLiteral returns true if the pattern is an exact name rather than a glob or regular expression
+func (p *Pattern) IsLiteral() bool {
+	return !p.glob && p.re == nil
+}
+
+// Match returns true if the name is matched by the pattern
+func (p *Pattern) Match(name string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(name)
+	case p.glob:
+		ok, _ := path.Match(strings.ToLower(p.raw), strings.ToLower(name))
+		return ok
+	}
+	return strings.EqualFold(p.raw, name)
+}
+
+// Resolve returns the names that the pattern matches, in the order given. As HAProxy's names are
+// case-sensitive, an exact name only matches the name of the same case if there is one, and otherwise the one
+// name that differs from it only in case. If several names differ only in case an AmbiguousNameError is
+// returned, without its Kind, as it is not known which was meant.
+func (p *Pattern) Resolve(names []string) ([]string, error) {
+	var matches []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if p.IsLiteral() && name == p.raw {
+			return []string{name}, nil
+		}
+		if p.Match(name) && !seen[name] {
+			seen[name] = true
+			matches = append(matches, name)
+		}
+	}
+	if p.IsLiteral() && len(matches) > 1 {
+		return nil, &AmbiguousNameError{Name: p.raw, Matches: matches}
+	}
+	return matches, nil
+}
+
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// Target is a backend, and the servers within it, that an action is to be applied to
+type Target struct {
+	Backend string
+	Servers []string
+}
+
+// ResolveTargets expands a backend pattern and a list of server patterns against a set of statistics, returning
+// every matching server grouped by backend, using the names exactly as HAProxy has them. Backends that match but
+// contain no matching servers are left out. If an exact backend or server name does not exist, a NameErrors is
+// returned with suggestions for what may have been meant, and if it could be several names that differ only in
+// case an AmbiguousNameError is returned. Either way no targets are returned.
+func (s *Statistics) ResolveTargets(backend *Pattern, servers []*Pattern) ([]Target, error) {
+	backends, err := backend.Resolve(s.Backends())
+	if err != nil {
+		err.(*AmbiguousNameError).Kind = "backend"
+		return nil, err
+	}
+	if len(backends) == 0 {
+		if !backend.IsLiteral() {
+			return nil, fmt.Errorf("no backend matches %v", backend)
+		}
+		return nil, NameErrors{{
+			Kind:        "backend",
+			Name:        backend.String(),
+			Suggestions: suggest(backend.String(), s.Backends()),
+		}}
+	}
+
+	var targets []Target
+	var candidates []string
+	serverFound := make([]bool, len(servers))
+	for _, b := range backends {
+		names := s.Servers(b)
+		candidates = append(candidates, names...)
+
+		matched := make(map[string]bool)
+		for i, p := range servers {
+			found, err := p.Resolve(names)
+			if err != nil {
+				ambiguous := err.(*AmbiguousNameError)
+				ambiguous.Kind, ambiguous.Backend = "server", b
+				return nil, ambiguous
+			}
+			for _, name := range found {
+				serverFound[i] = true
+				matched[name] = true
+			}
+		}
+
+		//Servers are kept in the order HAProxy reports them, rather than the order they were asked for
+		t := Target{Backend: b}
+		for _, name := range names {
+			if matched[name] {
+				t.Servers = append(t.Servers, name)
+			}
+		}
+		if len(t.Servers) > 0 {
+			targets = append(targets, t)
+		}
+	}
+
+	backendName := backend.String()
+	if backend.IsLiteral() {
+		backendName = backends[0]
+	} else if backend.raw == AllBackends {
+		backendName = ""
+	}
+
+	var nameErrors NameErrors
+	for i, p := range servers {
+		if !p.IsLiteral() || serverFound[i] {
+			continue
+		}
+		nameErrors = append(nameErrors, &UnknownNameError{
+			Kind:        "server",
+			Name:        p.String(),
+			Backend:     backendName,
+			Suggestions: suggest(p.String(), candidates),
+		})
+	}
+	if nameErrors != nil {
+		return nil, nameErrors
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no servers in backend %v match", backend)
+	}
+
+	return targets, nil
+}
+
+// ActionResult is the outcome of sending an action to the servers of a single backend
+type ActionResult struct {
+	Target
+	Done  bool
+	AllOK bool
+	Err   error
+}
+
+// SendActionAllBackends sends an action to the given servers in every backend that contains them. For example,
+// putting a server into maintenance in its http, https and api backends at once. Servers can be exact names or
+// patterns, as accepted by ParsePattern. The result for each backend is returned, or an error if the servers could
+// not be resolved, in which case no action is sent.
+func (c *HAProxyConfig) SendActionAllBackends(servers []string, action Action) ([]ActionResult, error) {
+	backend, _ := ParsePattern(AllBackends)
+	patterns, err := ParsePatterns(servers)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := c.GetStats()
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := stats.ResolveTargets(backend, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SendActionToTargets(targets, action), nil
+}
+
+// SendActionToTargets sends an action to each target in turn, as returned by ResolveTargets, and returns the
+// result for each backend
+func (c *HAProxyConfig) SendActionToTargets(targets []Target, action Action) []ActionResult {
+	var results []ActionResult
+	for _, t := range targets {
+		done, allok, err := c.SendAction(t.Servers, t.Backend, action)
+		results = append(results, ActionResult{
+			Target: t,
+			Done:   done,
+			AllOK:  allok,
+			Err:    err,
+		})
+	}
+	return results
+}
@@ -0,0 +1,122 @@
+package haproxyctl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testStats() *Statistics {
+	var s Statistics
+	add := func(backend string, servers ...string) {
+		for _, name := range servers {
+			s = append(s, Statistic{Type: Server, BackendName: backend, FrontendName: name})
+		}
+		s = append(s, Statistic{Type: Backend, BackendName: backend, FrontendName: "BACKEND"})
+	}
+	add("prod-web", "Web01", "web01", "web02")
+	add("prod-api", "API01", "web02")
+	add("Prod-API", "api02")
+	add("sorry-page")
+	return &s
+}
+
+func resolve(t *testing.T, backend string, servers ...string) ([]Target, error) {
+	t.Helper()
+	b, err := ParsePattern(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := ParsePatterns(servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testStats().ResolveTargets(b, patterns)
+}
+
+func TestResolveTargetsCase(t *testing.T) {
+	tests := []struct {
+		backend string
+		servers []string
+		want    []Target
+	}{
+		//Exact case only matches that name, even when another differs only in case
+		{"prod-web", []string{"web01"}, []Target{{"prod-web", []string{"web01"}}}},
+		{"prod-web", []string{"Web01"}, []Target{{"prod-web", []string{"Web01"}}}},
+		//A name in another case resolves to the one name it could be
+		{"PROD-WEB", []string{"WEB02"}, []Target{{"prod-web", []string{"web02"}}}},
+		{"prod-api", []string{"api01"}, []Target{{"prod-api", []string{"API01"}}}},
+		{"Prod-API", []string{"API02"}, []Target{{"Prod-API", []string{"api02"}}}},
+		//Globs still match every case
+		{"prod-web", []string{"web0*"}, []Target{{"prod-web", []string{"Web01", "web01", "web02"}}}},
+		{"*", []string{"web02"}, []Target{{"prod-web", []string{"web02"}}, {"prod-api", []string{"web02"}}}},
+	}
+	for _, tt := range tests {
+		got, err := resolve(t, tt.backend, tt.servers...)
+		if err != nil {
+			t.Errorf("%v %v: %v", tt.backend, tt.servers, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v %v: got %v, want %v", tt.backend, tt.servers, got, tt.want)
+		}
+	}
+}
+
+func TestResolveTargetsAmbiguous(t *testing.T) {
+	tests := []struct {
+		backend string
+		servers []string
+		kind    string
+	}{
+		{"prod-web", []string{"WEB01"}, "server"},
+		{"PROD-API", []string{"web02"}, "backend"},
+	}
+	for _, tt := range tests {
+		_, err := resolve(t, tt.backend, tt.servers...)
+		ambiguous, ok := err.(*AmbiguousNameError)
+		if !ok {
+			t.Errorf("%v %v: got %v, want an AmbiguousNameError", tt.backend, tt.servers, err)
+			continue
+		}
+		if ambiguous.Kind != tt.kind || len(ambiguous.Matches) != 2 {
+			t.Errorf("%v %v: got %+v", tt.backend, tt.servers, ambiguous)
+		}
+	}
+}
+
+func TestResolveTargetsUnknown(t *testing.T) {
+	_, err := resolve(t, "prod-web", "web03")
+	errs, ok := err.(NameErrors)
+	if !ok || len(errs) != 1 || errs[0].Kind != "server" || errs[0].Backend != "prod-web" {
+		t.Fatalf("got %v, want an unknown server in prod-web", err)
+	}
+	if _, err := resolve(t, "prod-db", "web01"); err == nil {
+		t.Fatal("an unknown backend was resolved")
+	}
+	//A backend without servers is known, so only its servers are unknown
+	_, err = resolve(t, "sorry-page", "web01")
+	errs, ok = err.(NameErrors)
+	if !ok || len(errs) != 1 || errs[0].Kind != "server" || errs[0].Backend != "sorry-page" {
+		t.Fatalf("got %v, want an unknown server in sorry-page", err)
+	}
+}
+
+func TestSplitPatterns(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"web01", []string{"web01"}},
+		{"web01,web02,api*", []string{"web01", "web02", "api*"}},
+		{"/^web0{1,2}$/", []string{"/^web0{1,2}$/"}},
+		{"/web0[1,2]/,api01", []string{"/web0[1,2]/", "api01"}},
+		{"api01,/web0[1,2]/", []string{"api01", "/web0[1,2]/"}},
+		//A / that never closes is a name like any other
+		{"/web,api01", []string{"/web", "api01"}},
+	}
+	for _, tt := range tests {
+		if got := SplitPatterns(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// columnViews are the preset column sets that can be given to -columns
+var columnViews = map[string][]string{
+	"default":  {"pxname", "svname", "status", "last_chk", "downtime"},
+	"health":   {"pxname", "svname", "status", "check_status", "last_chk", "chkfail", "chkdown", "lastchg", "downtime"},
+	"traffic":  {"pxname", "svname", "status", "scur", "smax", "stot", "rate", "req_rate", "bin", "bout"},
+	"errors":   {"pxname", "svname", "status", "ereq", "econ", "eresp", "wretr", "wredis", "hrsp_4xx", "hrsp_5xx", "cli_abrt", "srv_abrt"},
+	"latency":  {"pxname", "svname", "status", "qtime", "ctime", "rtime", "ttime", "check_duration"},
+	"capacity": {"pxname", "svname", "status", "weight", "scur", "smax", "slim", "qcur", "qmax", "qlimit"},
+}
+
+// columnHeaders overrides the Go field name used as a table heading for some fields
+var columnHeaders = map[string]string{
+	"BackendName":  "Backend",
+	"FrontendName": "Server",
+}
+
+var lbColumn = column{Key: "lb", Header: "LoadBalancer", Value: func(r interface{}) interface{} { return r.(*statRow).LB }}
+
+var errorColumn = column{Key: "error", Header: "Error", Value: func(r interface{}) interface{} { return errorValue(r.(*statRow).Err) }}
+
+// viewNames returns the names of the preset column views
+func viewNames() []string {
+	var names []string
+	for k := range columnViews {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseColumns turns a comma-separated list of view names and Statistic fields into the columns for get. The
+// load balancer and error columns are always included. If rates is set, counters show their per-second rates.
+func parseColumns(spec string, rates bool) ([]column, error) {
+	var names []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if view, ok := columnViews[strings.ToLower(name)]; ok {
+			names = append(names, view...)
+			continue
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	columns := []column{lbColumn}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if strings.EqualFold(name, "lb") || strings.EqualFold(name, "error") {
+			continue
+		}
+		f, ok := haproxyctl.LookupField(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %v (valid views are %v)", name, strings.Join(viewNames(), ", "))
+		}
+		if seen[f.Name] {
+			continue
+		}
+		seen[f.Name] = true
+		if rates && f.Counter {
+			columns = append(columns, rateColumn(f))
+			continue
+		}
+		columns = append(columns, fieldColumn(f))
+	}
+	return append(columns, errorColumn), nil
+}
+
+// statColumn creates a column for a statistic field. Rows that only carry a load balancer error have no value.
+func statColumn(key, header string, value func(s *haproxyctl.Statistic) interface{}, text func(s *haproxyctl.Statistic) string) column {
+	col := column{
+		Key:    key,
+		Header: header,
+		Value: func(r interface{}) interface{} {
+			if r.(*statRow).Err != nil {
+				return nil
+			}
+			return value(&r.(*statRow).Statistic)
+		},
+	}
+	if text != nil {
+		col.Text = func(r interface{}) string {
+			if r.(*statRow).Err != nil {
+				return ""
+			}
+			return text(&r.(*statRow).Statistic)
+		}
+	}
+	return col
+}
+
+// fieldColumn creates a column for a Statistic field, with human-readable formatting for tables
+func fieldColumn(f haproxyctl.Field) column {
+	header := f.Name
+	if h, ok := columnHeaders[f.Name]; ok {
+		header = h
+	}
+
+	value := func(s *haproxyctl.Statistic) interface{} {
+		return machineValue(f.Value(s))
+	}
+	text := func(s *haproxyctl.Statistic) string {
+		return humanValue(f.Value(s), f.Unit)
+	}
+	col := statColumn(f.Stat, header, value, text)
+
+	if f.Name == "Status" {
+		col.Text = func(r interface{}) string {
+			if r.(*statRow).Err != nil {
+				return "ERROR"
+			}
+			return r.(*statRow).Status
+		}
+	}
+	return col
+}
+
+// rateColumn creates a column for the per-second rate of a counter field
+func rateColumn(f haproxyctl.Field) column {
+	header := f.Name + "/s"
+	if h, ok := columnHeaders[f.Name]; ok {
+		header = h + "/s"
+	}
+
+	return column{
+		Key:    f.Stat + "_rate",
+		Header: header,
+		Value: func(r interface{}) interface{} {
+			rate, ok := r.(*statRow).Rates[f.Name]
+			if !ok {
+				return nil
+			}
+			return rate
+		},
+		Text: func(r interface{}) string {
+			rate, ok := r.(*statRow).Rates[f.Name]
+			if !ok {
+				return ""
+			}
+			if f.Unit == haproxyctl.UnitBytes {
+				return formatBytes(uint64(rate)) + "/s"
+			}
+			return fmt.Sprintf("%.2f/s", rate)
+		},
+	}
+}
+
+// machineValue converts a Statistic field value for the machine-readable output formats
+func machineValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case haproxyctl.Duration:
+		return x.Seconds()
+	case haproxyctl.EntryType:
+		return x.String()
+	}
+	return v
+}
+
+// humanValue formats a Statistic field value for display in a table
+func humanValue(v interface{}, unit haproxyctl.Unit) string {
+	switch x := v.(type) {
+	case haproxyctl.Duration:
+		return x.String()
+	case uint64:
+		switch unit {
+		case haproxyctl.UnitBytes:
+			return formatBytes(x)
+		case haproxyctl.UnitMilliseconds:
+			return (time.Duration(x) * time.Millisecond).String()
+		}
+	}
+	return formatText(machineValue(v))
+}
+
+// formatBytes formats a byte count using binary prefixes, such as 1.5 MiB
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// command is one of haproxyctl's commands, or a subcommand of one
+type command struct {
+	Name           string
+	Usage          string // Flags and arguments, as in [-listen :8080]
+	Summary        string // One line description, for the list of commands
+	Help           string // Longer description, for its usage
+	Run            func(c *HAProxyCtlConfig, args []string) error
+	Commands       []*command // Subcommands, picked by the first argument, instead of Run
+	Offline        bool       // Can use statistics from -from-file
+	NoInit         bool       // Does not talk to the load balancers, so does not need their credentials
+	NoConfig       bool       // Does not need a configuration file
+	OptionalConfig bool       // Uses the configuration file if there is one, but runs without one
+	Hidden         bool       // Left out of the list of commands, as it is only run by other programs
+
+	path string // Name, after the names of the commands it is under
+}
+
+// commands are the top-level commands. They are set in init, as help refers back to them.
+var commands []*command
+
+// commandPaths finds a command by its path, such as config validate
+var commandPaths = make(map[string]*command)
+
+// patternHelp is how the servers and backends of an action are given, for the help of the server commands and
+// of haproxyctl
+const patternHelp = `Servers can be separate arguments or comma-separated, and servers and backends can be exact names,
+globs (ny-web*) or regular expressions (/^prod-(web|api)$/), which keep any commas in them.
+Patterns are expanded against the live statistics of each load balancer before the action is sent.
+Names are matched regardless of case when only one name could be meant, names that differ only in case must
+be given exactly, and unknown names are reported with suggestions instead of being sent.`
+
+func init() {
+	server := &command{
+		Name:    CommandServer,
+		Summary: "Sends an action, such as drain or maint, to servers",
+		Help: `Sends an action to servers in a backend, or in every backend that has them with -all-backends.
+` + patternHelp,
+	}
+	for _, a := range haproxyctl.Actions {
+		server.Commands = append(server.Commands, &command{
+			Name:    string(a),
+			Usage:   "[-backend backend | -all-backends] [-yes] [-confirm-over n] " + outputUsage + " server...",
+			Summary: actionSummaries[a],
+			Help:    patternHelp,
+			Run:     runServerAction(a),
+		})
+	}
+
+	commands = []*command{
+		server,
+		{
+			Name:    CommandStats,
+			Summary: "Gets the statistics of every load balancer",
+			Offline: true,
+			Commands: []*command{{
+				Name:    ActionGetDetail,
+				Usage:   "[-columns list] [-where expression] [-sort fields] [-limit n] [-interval 10s] " + outputUsage,
+				Summary: "Gets the status of the servers, or with -where the frontends and backends",
+				Help: `The flags default to the global flags of the same name, which the original get form uses.
+See haproxyctl help for the columns and the syntax of expressions.`,
+				Run: runStatsGet,
+			}},
+		},
+		{
+			Name:    CommandTop,
+			Usage:   "[-interval 2s] [-where expression]",
+			Summary: "Shows a live, full-screen view of every load balancer's backends and servers",
+			Help:    "Keys: q quit, s cycle sort, r reverse sort, / filter by expression, c clear filter",
+			Run:     runTop,
+			Offline: true,
+		},
+		{
+			Name:    CommandSummary,
+			Usage:   outputUsage + " [backend]",
+			Summary: "Shows one line per backend, across every load balancer",
+			Help: `Shows one line per backend with its servers UP on each load balancer, and the total sessions, queue
+and 5xx responses across all of them. The backend can be a pattern.`,
+			Run:     runSummary,
+			Offline: true,
+		},
+		{
+			Name:    CommandExporter,
+			Usage:   "[-listen :9101] [-path /metrics] [-interval 15s]",
+			Summary: "Serves Prometheus metrics for every load balancer, scraped in the background",
+			Run:     runExporter,
+		},
+		{
+			Name:    CommandServe,
+			Usage:   "[-listen :8080]",
+			Summary: "Serves a web dashboard and a JSON API over every load balancer",
+			Help: `Serves a web dashboard of every load balancer side-by-side at /, and a JSON API over them, for the
+[[APITokens]] in the configuration:
+    GET /api/v1/loadbalancers, GET /api/v1/stats?lb=&select=&columns=&where=&sort=&limit=
+    and POST /api/v1/actions {"action", "servers", "backend", "all_backends", "lb", "select"}`,
+			Run: runServe,
+		},
+		{
+			Name:    CommandEvents,
+			Usage:   "[-interval 5s]",
+			Summary: "Prints a line of JSON for every change in state on the load balancers",
+			Help: `Prints a line of JSON for every server that goes DOWN or UP, enters MAINT or DRAIN or changes check
+status, every backend that loses all of its servers, and every load balancer that becomes unreachable.`,
+			Run: runEvents,
+		},
+		{
+			Name:    CommandNotify,
+			Usage:   "[-interval 5s]",
+			Summary: "Sends events to the [[Webhooks]] in the configuration",
+			Help: `Sends events to the [[Webhooks]] in the configuration as HTTP POSTs, with retries. Failures that
+recover within a webhook's Hold are dropped, and repeats within its Quiet period (default 5m) are not
+sent again.`,
+			Run: runNotify,
+		},
+		{
+			Name:    CommandSnapshot,
+			Summary: "Saves the statistics of every load balancer to a file, or compares two",
+			Commands: []*command{
+				{
+					Name:    "save",
+					Usage:   "[-dir .] [-file snapshot.json]",
+					Summary: "Writes the raw statistics of every load balancer to a timestamped file",
+					Run:     snapshotSave,
+				},
+				{
+					Name:    "diff",
+					Usage:   "[-counters stot,hrsp_5xx,...|all|none] " + outputUsage + " before.json after.json",
+					Summary: "Shows what changed between two snapshots",
+					Help: `Shows what was added, removed or changed status or weight between two snapshots, and how
+counters grew.`,
+					Run: snapshotDiff,
+				},
+			},
+		},
+		{
+			Name:    CommandSecret,
+			Summary: "Encrypts a password for the configuration",
+			NoInit:  true,
+			Commands: []*command{{
+				Name:    "encrypt",
+				Usage:   "[-key-file file | -passphrase]",
+				Summary: "Reads a password from stdin and prints it encrypted, for use as a Password",
+				Help: `Encrypts with AES-GCM. The key is the KeyFile in the configuration, -key-file, or a passphrase from
+$HAPROXYCTL_PASSPHRASE, which is also needed to decrypt it. The configuration file is only read for its KeyFile,
+so it is not needed with -key-file or a passphrase.`,
+				Run:            runSecretEncrypt,
+				OptionalConfig: true,
+			}},
+		},
+		{
+			Name:    CommandConfig,
+			Summary: "Shows or validates the configuration",
+			NoInit:  true,
+			Commands: []*command{
+				{
+					Name:    "show",
+					Usage:   "[-format toml|yaml|json]",
+					Summary: "Prints the configuration after the files are merged, with secrets redacted",
+					Run:     runConfigShow,
+				},
+				{
+					Name:    "validate",
+					Usage:   "[-no-connect] [-timeout 10s] " + outputUsage,
+					Summary: "Lists the problems with the configuration",
+					Help: `Lists unknown keys, invalid URLs, duplicate names, missing credentials and stats pages that
+cannot be reached or do not allow actions.`,
+					Run: runConfigValidate,
+				},
+			},
+		},
+		{
+			Name:    CommandCompletion,
+			Summary: "Prints a script that completes commands, flags and names for bash, zsh or fish",
+			Help: `Load balancers, backends and servers are completed from a cached copy of the last statistics
+fetched, which is refreshed in the background when it is more than a minute old.
+    bash: source <(haproxyctl completion bash)
+    zsh:  source <(haproxyctl completion zsh)
+    fish: haproxyctl completion fish | source`,
+			Commands: []*command{
+				{Name: "bash", Summary: "Prints the completion script for bash", Run: completionScript("bash"), NoConfig: true, NoInit: true},
+				{Name: "zsh", Summary: "Prints the completion script for zsh", Run: completionScript("zsh"), NoConfig: true, NoInit: true},
+				{Name: "fish", Summary: "Prints the completion script for fish", Run: completionScript("fish"), NoConfig: true, NoInit: true},
+				{Name: "refresh", Summary: "Fetches the statistics of every load balancer, and caches their names for completion", Run: runCompletionRefresh},
+			},
+		},
+		{
+			Name:     completeCommand,
+			Summary:  "Prints the completions for the words of a command line, for the completion scripts",
+			Run:      runComplete,
+			NoInit:   true,
+			NoConfig: true,
+			Hidden:   true,
+		},
+		{
+			Name:     CommandHelp,
+			Usage:    "[command [subcommand]]",
+			Summary:  "Shows the help for a command, as in: haproxyctl help server drain",
+			Run:      runHelp,
+			NoInit:   true,
+			NoConfig: true,
+		},
+	}
+	setCommandPaths(commands, &command{})
+}
+
+// setCommandPaths records the path of every command. Subcommands are Offline, NoInit and NoConfig if the
+// command they are under is.
+func setCommandPaths(cmds []*command, parent *command) {
+	for _, cmd := range cmds {
+		cmd.path = strings.TrimSpace(parent.path + " " + cmd.Name)
+		cmd.Offline = cmd.Offline || parent.Offline
+		cmd.NoInit = cmd.NoInit || parent.NoInit
+		cmd.NoConfig = cmd.NoConfig || parent.NoConfig
+		commandPaths[cmd.path] = cmd
+		setCommandPaths(cmd.Commands, cmd)
+	}
+}
+
+// actionSummaries describe each action, for the list of server commands
+var actionSummaries = map[haproxyctl.Action]string{
+	haproxyctl.ActionSetStateToReady:     "Sets the server state to 'ready'",
+	haproxyctl.ActionSetStateToDrain:     "Sets the server state to 'drain'",
+	haproxyctl.ActionSetStateToMaint:     "Sets the server state to 'maintenance'",
+	haproxyctl.ActionHealthDisableChecks: "Disables health checks",
+	haproxyctl.ActionHealthEnableChecks:  "Enables health checks",
+	haproxyctl.ActionHealthForceUp:       "Forces the server to be UP",
+	haproxyctl.ActionHealthForceNoLB:     "Forces the server to disable load balancing",
+	haproxyctl.ActionHealthForceDown:     "Forces the server to be DOWN",
+	haproxyctl.ActionAgentDisablechecks:  "Disables agent checks",
+	haproxyctl.ActionAgentEnablechecks:   "Enables agent checks",
+	haproxyctl.ActionAgentForceUp:        "Forces agent to be UP",
+	haproxyctl.ActionAgentForceDown:      "Forces agent to be DOWN",
+	haproxyctl.ActionKillSessions:        "Kills all sessions",
+}
+
+// findCommand returns the command with a name, regardless of case, from a list of commands
+func findCommand(cmds []*command, name string) *command {
+	for _, cmd := range cmds {
+		if strings.EqualFold(cmd.Name, name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// leaf returns the subcommand that the arguments run, or the command itself if they do not name one
+func (cmd *command) leaf(args []string) *command {
+	for len(args) > 0 {
+		sub := findCommand(cmd.Commands, args[0])
+		if sub == nil {
+			break
+		}
+		cmd, args = sub, args[1:]
+	}
+	return cmd
+}
+
+// legacyArgs turns the original forms of the command line, action servers backend, -all-backends action
+// servers and get, into the server and stats commands they are the same as
+func legacyArgs(args []string) ([]string, error) {
+	name := strings.ToLower(args[0])
+	if name == ActionGetDetail {
+		return append([]string{CommandStats, ActionGetDetail}, args[1:]...), nil
+	}
+	if !haproxyctl.Action(name).IsValid() {
+		return nil, fmt.Errorf("Invalid command specified (%v)", args[0])
+	}
+
+	if *allBackends {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("Invalid number of arguments, must specify an action and servers when using -all-backends")
+		}
+		return []string{CommandServer, name, "-all-backends", "--", args[1]}, nil
+	}
+	if len(args) != 3 {
+		return nil, fmt.Errorf("Invalid number of arguments, must specify an action, servers and a backend")
+	}
+	return []string{CommandServer, name, "-backend", args[2], "--", args[1]}, nil
+}
+
+// run runs the command, or the subcommand named by its first argument
+func (cmd *command) run(c *HAProxyCtlConfig, args []string) error {
+	if len(cmd.Commands) == 0 {
+		return cmd.Run(c, args)
+	}
+
+	if len(args) > 0 && isHelpFlag(args[0]) {
+		cmd.printUsage(os.Stderr)
+		return nil
+	}
+	var names []string
+	for _, sub := range cmd.Commands {
+		names = append(names, sub.Name)
+	}
+	if len(args) == 0 {
+		cmd.printUsage(os.Stderr)
+		return fmt.Errorf("%v needs one of: %v", cmd.path, strings.Join(names, ", "))
+	}
+	sub := findCommand(cmd.Commands, args[0])
+	if sub == nil {
+		return fmt.Errorf("unknown %v command %v, must be one of: %v", cmd.path, args[0], strings.Join(names, ", "))
+	}
+	return sub.run(c, args[1:])
+}
+
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help" || arg == "help"
+}
+
+// printUsage prints how to use the command, and lists its subcommands
+func (cmd *command) printUsage(w io.Writer) {
+	usage := cmd.Usage
+	if len(cmd.Commands) > 0 {
+		usage = "<command> [flags] [arguments]"
+	}
+	fmt.Fprintf(w, "Usage: haproxyctl [global flags] %v %v\n", cmd.path, usage)
+	if cmd.Help != "" {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, cmd.Help)
+	} else if cmd.Summary != "" {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, cmd.Summary)
+	}
+
+	if len(cmd.Commands) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Commands:")
+		printCommandList(w, cmd.Commands)
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Run haproxyctl help %v <command> for the flags and arguments of each command.\n", cmd.path)
+	}
+}
+
+// printCommandList prints the name and summary of each command
+func printCommandList(w io.Writer, cmds []*command) {
+	width := 0
+	for _, cmd := range cmds {
+		if !cmd.Hidden && len(cmd.Name) > width {
+			width = len(cmd.Name)
+		}
+	}
+	for _, cmd := range cmds {
+		if !cmd.Hidden {
+			fmt.Fprintf(w, "    %-*v - %v\n", width, cmd.Name, cmd.Summary)
+		}
+	}
+}
+
+// newFlagSet returns the flags for a command, given by its path, whose -h shows the command's usage
+func newFlagSet(path string) *flag.FlagSet {
+	flags := flag.NewFlagSet(path, flag.ContinueOnError)
+	flags.Usage = func() {
+		w := flags.Output()
+		if cmd, ok := commandPaths[path]; ok {
+			cmd.printUsage(w)
+		}
+		hasFlags := false
+		flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "Flags:")
+			flags.PrintDefaults()
+		}
+	}
+	return flags
+}
+
+// parseFlags parses a command's flags. For -h, the usage is printed and flag.ErrHelp returned, so that the command
+// returns without running. Other mistakes exit with status 2, once the flag package has printed them.
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil && err != flag.ErrHelp {
+		os.Exit(2)
+	}
+	return err
+}
+
+// runHelp shows the help for haproxyctl, or for a command
+func runHelp(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandHelp)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	if len(args) == 0 {
+		printHelp()
+		return nil
+	}
+
+	cmd := findCommand(commands, args[0])
+	for _, name := range args[1:] {
+		if cmd == nil {
+			break
+		}
+		cmd = findCommand(cmd.Commands, name)
+	}
+	if cmd == nil {
+		return fmt.Errorf("unknown command %v, see haproxyctl help", strings.Join(args, " "))
+	}
+	if cmd.Run == nil {
+		cmd.printUsage(os.Stderr)
+		return nil
+	}
+	//Every command parses its flags before doing anything else, so -h prints its usage, with the flags, and
+	//returns flag.ErrHelp without running it
+	if err := cmd.Run(c, []string{"-h"}); err != flag.ErrHelp {
+		return err
+	}
+	return nil
+}
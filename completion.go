@@ -0,0 +1,555 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// completeCommand is the hidden command the completion scripts run, with the words of the command line
+const completeCommand = "__complete"
+
+// completionFiles is printed in place of candidates when the shell should complete file names
+const completionFiles = ":files"
+
+// completionCacheAge is how old the cached names can be before they are refreshed in the background
+const completionCacheAge = time.Minute
+
+// completionCache is the names of the load balancers, backends and servers in the last statistics fetched
+type completionCache struct {
+	Time          time.Time           `json:"time"`
+	LoadBalancers []string            `json:"load_balancers"`
+	Backends      map[string][]string `json:"backends"` // Servers in each backend
+}
+
+// completionCachePath returns where the names are cached for a set of configuration files, as each set can
+// have different load balancers
+func completionCachePath(files []string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New32a()
+	for _, f := range files {
+		if abs, err := filepath.Abs(f); err == nil {
+			f = abs
+		}
+		fmt.Fprintln(h, f)
+	}
+	return filepath.Join(dir, "haproxyctl", fmt.Sprintf("completion-%08x.json", h.Sum32())), nil
+}
+
+// readCompletionCache reads the cached names, or returns none if they have not been cached
+func readCompletionCache(path string) *completionCache {
+	cache := &completionCache{}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(data, cache)
+	}
+	return cache
+}
+
+// writeCompletionCache fetches the statistics of every load balancer and caches their names. Backends and
+// servers of load balancers that cannot be reached are kept from the last time.
+func (c *HAProxyCtlConfig) writeCompletionCache() error {
+	path, err := completionCachePath(c.files)
+	if err != nil {
+		return err
+	}
+
+	cache := &completionCache{Time: time.Now().UTC(), Backends: make(map[string][]string)}
+	servers := make(map[string]map[string]bool)
+	failed := false
+	for _, l := range c.fleet().GetStats() {
+		cache.LoadBalancers = append(cache.LoadBalancers, l.Name)
+		if l.Err != nil {
+			failed = true
+			continue
+		}
+		for _, s := range *l.Stats {
+			if s.Type != haproxyctl.Backend && s.Type != haproxyctl.Server {
+				continue
+			}
+			if servers[s.BackendName] == nil {
+				servers[s.BackendName] = make(map[string]bool)
+			}
+			if s.Type == haproxyctl.Server {
+				servers[s.BackendName][s.FrontendName] = true
+			}
+		}
+	}
+	if failed {
+		for backend, names := range readCompletionCache(path).Backends {
+			if servers[backend] == nil {
+				servers[backend] = make(map[string]bool)
+			}
+			for _, name := range names {
+				servers[backend][name] = true
+			}
+		}
+	}
+	for backend, names := range servers {
+		cache.Backends[backend] = sortedKeys(names)
+	}
+	sort.Strings(cache.LoadBalancers)
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runCompletionRefresh caches the names of the load balancers, backends and servers for completion
+func runCompletionRefresh(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandCompletion + " refresh")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	return c.writeCompletionCache()
+}
+
+// completionScript returns a completion subcommand, which prints the script for a shell
+func completionScript(shell string) func(c *HAProxyCtlConfig, args []string) error {
+	return func(c *HAProxyCtlConfig, args []string) error {
+		flags := newFlagSet(CommandCompletion + " " + shell)
+		if err := parseFlags(flags, args); err != nil {
+			return err
+		}
+		fmt.Print(completionScripts[shell])
+		return nil
+	}
+}
+
+// completionScripts are the completion scripts for each shell
+var completionScripts = map[string]string{
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+// The completion scripts pass the words of the command line, up to and including the one being completed, to
+// haproxyctl __complete, and offer the lines it prints. A line of :files asks for file names instead.
+const bashCompletion = `# bash completion for haproxyctl, from: haproxyctl completion bash
+_haproxyctl() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	local IFS=$'\n'
+	local candidates=($("${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+	if [ "${candidates[0]}" = ":files" ]; then
+		compopt -o filenames 2>/dev/null
+		COMPREPLY=($(compgen -f -- "$cur"))
+	else
+		COMPREPLY=("${candidates[@]}")
+	fi
+}
+complete -F _haproxyctl haproxyctl
+`
+
+const zshCompletion = `#compdef haproxyctl
+# zsh completion for haproxyctl, from: haproxyctl completion zsh
+_haproxyctl() {
+	local -a candidates
+	candidates=("${(@f)$("${words[1]}" __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ "${candidates[1]}" == ":files" ]]; then
+		_files
+	elif [[ -n "${candidates[1]}" ]]; then
+		compadd -- "${candidates[@]}"
+	fi
+}
+if [[ "${funcstack[1]}" == "_haproxyctl" ]]; then
+	_haproxyctl "$@"
+else
+	compdef _haproxyctl haproxyctl
+fi
+`
+
+const fishCompletion = `# fish completion for haproxyctl, from: haproxyctl completion fish
+function __haproxyctl_complete
+	set -l tokens (commandline -opc)
+	set -l cmd $tokens[1]
+	set -e tokens[1]
+	set -l cur (commandline -ct)
+	set -l candidates ($cmd __complete $tokens "$cur" 2>/dev/null)
+	if test "$candidates[1]" = ":files"
+		__fish_complete_path "$cur"
+	else
+		printf '%s\n' $candidates
+	end
+end
+complete -c haproxyctl -f -a '(__haproxyctl_complete)'
+`
+
+// usageFlag matches the flags in a command's usage, and the value each takes, as in -listen :8080
+var usageFlag = regexp.MustCompile(`(?:^|[\s\[|])-([a-z][a-z-]*)(?: ([^\s\]]+))?`)
+
+// usageFlags returns the flags in a command's usage, with the value each takes, which is empty for flags that
+// take no value
+func usageFlags(usage string) map[string]string {
+	flags := make(map[string]string)
+	for _, m := range usageFlag.FindAllStringSubmatch(usage, -1) {
+		value := m[2]
+		if value == "|" {
+			value = ""
+		}
+		flags[m[1]] = value
+	}
+	return flags
+}
+
+// completion is what has been typed on a command line, for completing the next word
+type completion struct {
+	config *HAProxyCtlConfig
+	cache  *completionCache
+
+	cmd    *command          // Deepest command typed, or nil if none has been
+	action haproxyctl.Action // Action of the original form, action servers backend
+	flags  map[string]string // Flags typed, with their values
+	args   []string          // Arguments after the command
+}
+
+// runComplete prints the candidates for the last of the words of a command line, one per line
+func runComplete(c *HAProxyCtlConfig, args []string) error {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	comp := &completion{flags: make(map[string]string)}
+	pending := comp.parse(args[:len(args)-1])
+	for _, candidate := range comp.candidates(pending, args[len(args)-1]) {
+		fmt.Println(candidate)
+	}
+	return nil
+}
+
+// parse reads the words before the one being completed. It returns the flag whose value is being typed, if
+// any.
+func (comp *completion) parse(words []string) string {
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if w == "--" {
+			continue
+		}
+		if strings.HasPrefix(w, "-") && w != "-" && comp.action == "" {
+			name := strings.TrimLeft(w, "-")
+			if eq := strings.Index(name, "="); eq >= 0 {
+				comp.flags[name[:eq]] = name[eq+1:]
+				continue
+			}
+			comp.flags[name] = ""
+			if comp.takesValue(name) {
+				if i == len(words)-1 {
+					return name
+				}
+				i++
+				comp.flags[name] = words[i]
+			}
+			continue
+		}
+
+		switch {
+		case comp.cmd == nil && comp.action == "":
+			if comp.cmd = findCommand(commands, w); comp.cmd == nil {
+				comp.action = haproxyctl.Action(strings.ToLower(w))
+			}
+		case comp.cmd != nil && findCommand(comp.cmd.Commands, w) != nil:
+			comp.cmd = findCommand(comp.cmd.Commands, w)
+		default:
+			comp.args = append(comp.args, w)
+		}
+	}
+	return ""
+}
+
+// takesValue returns true if a flag takes a value, rather than being true or false
+func (comp *completion) takesValue(name string) bool {
+	if comp.cmd == nil {
+		f := flag.Lookup(name)
+		if f == nil {
+			return false
+		}
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		return !ok || !b.IsBoolFlag()
+	}
+	return usageFlags(comp.cmd.Usage)[name] != ""
+}
+
+// candidates returns the completions of a word, either the value of a flag or the next argument
+func (comp *completion) candidates(pending, word string) []string {
+	if pending != "" {
+		return comp.flagValues(pending, word)
+	}
+
+	if strings.HasPrefix(word, "-") && comp.action == "" {
+		var names []string
+		if comp.cmd == nil {
+			flag.VisitAll(func(f *flag.Flag) { names = append(names, "-"+f.Name) })
+		} else {
+			for name := range usageFlags(comp.cmd.Usage) {
+				names = append(names, "-"+name)
+			}
+			sort.Strings(names)
+		}
+		return matching(names, word)
+	}
+
+	switch {
+	case comp.action == ActionGetDetail:
+		return nil
+	case comp.action != "":
+		//The original form is action servers backend, or -all-backends action servers
+		_, all := comp.flags["all-backends"]
+		switch {
+		case len(comp.args) == 0:
+			return matchingList(comp.servers(""), word)
+		case len(comp.args) == 1 && !all:
+			return matching(comp.backends(), word)
+		}
+		return nil
+	case comp.cmd == nil:
+		var names []string
+		for _, cmd := range commands {
+			if !cmd.Hidden {
+				names = append(names, cmd.Name)
+			}
+		}
+		for _, a := range haproxyctl.Actions {
+			names = append(names, string(a))
+		}
+		return matching(append(names, ActionGetDetail), word)
+	case len(comp.cmd.Commands) > 0:
+		return matching(commandNames(comp.cmd.Commands), word)
+	case comp.cmd.path == CommandHelp:
+		cmds := commands
+		for _, arg := range comp.args {
+			if cmd := findCommand(cmds, arg); cmd != nil {
+				cmds = cmd.Commands
+			} else {
+				cmds = nil
+			}
+		}
+		return matching(commandNames(cmds), word)
+	case strings.HasPrefix(comp.cmd.path, CommandServer+" "):
+		return matchingList(comp.servers(comp.flags["backend"]), word)
+	case comp.cmd.path == CommandSummary && len(comp.args) == 0:
+		return matching(comp.backends(), word)
+	case comp.cmd.path == CommandSnapshot+" diff":
+		return []string{completionFiles}
+	}
+	return nil
+}
+
+// flagValues returns the completions of a flag's value
+func (comp *completion) flagValues(name, word string) []string {
+	switch name {
+	case "lb":
+		return matchingList(comp.loadBalancers(), word)
+	case "select":
+		return matchingList(comp.tags(), word)
+	case "backend":
+		return matching(comp.backends(), word)
+	case "output":
+		return matching(outputFormats, word)
+	case "columns":
+		return matchingList(append(viewNames(), statNames()...), word)
+	case "sort":
+		return matchingList(statNames(), word)
+	case "config", "from-file", "file", "dir", "key-file":
+		return []string{completionFiles}
+	}
+
+	//Flags with a choice of values list them in their usage, as in -format toml|yaml|json
+	if comp.cmd != nil {
+		if value := usageFlags(comp.cmd.Usage)[name]; strings.Contains(value, "|") && !strings.Contains(value, "...") {
+			return matching(strings.Split(value, "|"), word)
+		}
+	}
+	return nil
+}
+
+func commandNames(cmds []*command) []string {
+	var names []string
+	for _, cmd := range cmds {
+		if !cmd.Hidden {
+			names = append(names, cmd.Name)
+		}
+	}
+	return names
+}
+
+// statNames returns the HAProxy names of the statistic fields
+func statNames() []string {
+	var names []string
+	for _, f := range haproxyctl.StatisticFields() {
+		names = append(names, f.Stat)
+	}
+	return names
+}
+
+// matching returns the candidates that start with the word, regardless of case
+func matching(candidates []string, word string) []string {
+	var matches []string
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(word)) && !seen[c] {
+			matches = append(matches, c)
+			seen[c] = true
+		}
+	}
+	return matches
+}
+
+// matchingList completes the last item of a comma-separated list, keeping the items before it and leaving
+// them out of the candidates
+func matchingList(candidates []string, word string) []string {
+	done, last := "", word
+	if i := strings.LastIndex(word, ","); i >= 0 {
+		done, last = word[:i+1], word[i+1:]
+	}
+	listed := make(map[string]bool)
+	for _, item := range strings.Split(done, ",") {
+		listed[strings.ToLower(item)] = true
+	}
+	var matches []string
+	for _, m := range matching(candidates, last) {
+		if !listed[strings.ToLower(m)] {
+			matches = append(matches, done+m)
+		}
+	}
+	return matches
+}
+
+// loadConfig reads the configuration given with -config on the command line, or found in the search path.
+// Load balancers are not looked for in LoadBalancerGroups, as that can be slow, but are in the cache.
+func (comp *completion) loadConfig() *HAProxyCtlConfig {
+	if comp.config == nil {
+		path, ok := comp.flags["config"]
+		if !ok {
+			path = *configLoc
+		}
+		if comp.config, _ = loadConfig(path); comp.config == nil {
+			comp.config = &HAProxyCtlConfig{}
+		}
+	}
+	return comp.config
+}
+
+// names returns the cached names, and refreshes them in the background if they are old
+func (comp *completion) names() *completionCache {
+	if comp.cache != nil {
+		return comp.cache
+	}
+	c := comp.loadConfig()
+	path, err := completionCachePath(c.files)
+	if err != nil || len(c.files) == 0 {
+		comp.cache = &completionCache{}
+		return comp.cache
+	}
+	comp.cache = readCompletionCache(path)
+
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < completionCacheAge {
+		return comp.cache
+	}
+	//The cache is touched first so that only one refresh is started while it runs
+	if err := os.Chtimes(path, time.Now(), time.Now()); os.IsNotExist(err) {
+		if os.MkdirAll(filepath.Dir(path), 0700) == nil {
+			ioutil.WriteFile(path, []byte("{}"), 0600)
+		}
+	}
+	args := []string{CommandCompletion, "refresh"}
+	if path, ok := comp.flags["config"]; ok {
+		args = append([]string{"-config", path}, args...)
+	} else if *configLoc != "" {
+		args = append([]string{"-config", *configLoc}, args...)
+	}
+	if self, err := os.Executable(); err == nil {
+		exec.Command(self, args...).Start()
+	}
+	return comp.cache
+}
+
+// loadBalancers returns the names and groups of the load balancers in the configuration, and those found in
+// LoadBalancerGroups the last time the names were cached
+func (comp *completion) loadBalancers() []string {
+	c := comp.loadConfig()
+	names := append([]string(nil), comp.names().LoadBalancers...)
+	for _, lb := range c.LoadBalancers {
+		names = append(names, lb.Name)
+		names = append(names, lb.Groups...)
+	}
+	for _, g := range c.LoadBalancerGroups {
+		names = append(names, g.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tags returns every tag of the load balancers and groups in the configuration, as key=value
+func (comp *completion) tags() []string {
+	c := comp.loadConfig()
+	var tags []string
+	add := func(m map[string]string) {
+		for k, v := range m {
+			tags = append(tags, k+"="+v)
+		}
+	}
+	for _, lb := range c.LoadBalancers {
+		add(lb.Tags)
+	}
+	for _, g := range c.LoadBalancerGroups {
+		add(g.Tags)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// backends returns the cached backend names
+func (comp *completion) backends() []string {
+	var names []string
+	for backend := range comp.names().Backends {
+		names = append(names, backend)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// servers returns the cached server names, only those in the backend if it is one of them
+func (comp *completion) servers(backend string) []string {
+	backends := comp.names().Backends
+	for name, servers := range backends {
+		if backend != "" && strings.EqualFold(name, backend) {
+			return servers
+		}
+	}
+	seen := make(map[string]bool)
+	for _, servers := range backends {
+		for _, s := range servers {
+			seen[s] = true
+		}
+	}
+	return sortedKeys(seen)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// configEnv is the environment variable that names a configuration file
+const configEnv = "HAPROXYCTL_CONFIG"
+
+// configExtensions are the formats a configuration file can be in, by extension
+var configExtensions = []string{".toml", ".yaml", ".yml", ".json"}
+
+// configSearchPath returns the places a configuration file is looked for when -config is not given, highest
+// precedence first. Entries ending in config.* can have any of the configExtensions.
+func configSearchPath() []string {
+	var paths []string
+	if env := os.Getenv(configEnv); env != "" {
+		paths = append(paths, env)
+	}
+	return append(paths, "config.toml", expandHome("~/.config/haproxyctl/config.*"), "/etc/haproxyctl/config.*")
+}
+
+// findConfigFiles returns the configuration files that exist in the search path, highest precedence first. A
+// directory with more than one config.* is an error, as it is not clear which is meant.
+func findConfigFiles() ([]string, error) {
+	var found []string
+	for _, path := range configSearchPath() {
+		if !strings.HasSuffix(path, ".*") {
+			if _, err := os.Stat(path); err == nil {
+				found = append(found, path)
+			} else if path == os.Getenv(configEnv) {
+				return nil, fmt.Errorf("$%v: %v", configEnv, err)
+			}
+			continue
+		}
+
+		var matches []string
+		for _, ext := range configExtensions {
+			candidate := strings.TrimSuffix(path, ".*") + ext
+			if _, err := os.Stat(candidate); err == nil {
+				matches = append(matches, candidate)
+			}
+		}
+		if len(matches) > 1 {
+			return nil, fmt.Errorf("more than one configuration file in %v (%v), there can only be one", filepath.Dir(path), strings.Join(matches, ", "))
+		}
+		found = append(found, matches...)
+	}
+	return found, nil
+}
+
+// noConfigError is returned by loadConfig when no configuration file is given and none is found
+type noConfigError struct{}
+
+func (noConfigError) Error() string {
+	return fmt.Sprintf("no configuration file found, use -config or put one in any of: %v", strings.Join(configSearchPath(), ", "))
+}
+
+// loadConfig reads the configuration files, highest precedence first, and merges them into one. If -config
+// was given only that file is read, otherwise every file in the search path is.
+func loadConfig(explicit string) (*HAProxyCtlConfig, error) {
+	files := []string{explicit}
+	if explicit == "" {
+		var err error
+		if files, err = findConfigFiles(); err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, noConfigError{}
+		}
+	}
+
+	merged := &HAProxyCtlConfig{}
+	for i := len(files) - 1; i >= 0; i-- {
+		var c HAProxyCtlConfig
+		problems, err := decodeConfigFile(files[i], &c)
+		if err != nil {
+			return nil, err
+		}
+		merged.merge(&c)
+		merged.problems = append(merged.problems, problems...)
+	}
+	merged.files = files
+	return merged, nil
+}
+
+// decodeConfigFile reads a TOML, YAML or JSON configuration file, depending on its extension. Keys that are
+// not part of the configuration are returned as problems, so that misspellings are not silently ignored.
+func decodeConfigFile(path string, c *HAProxyCtlConfig) (configProblems, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems configProblems
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), c)
+		for _, key := range md.Undecoded() {
+			problems = append(problems, configProblem{Severity: severityError, Check: checkUnknownKey, File: path, Field: key.String(), Message: "unknown key"})
+		}
+	case ".yaml", ".yml":
+		if err = yaml.UnmarshalStrict(data, c); err != nil {
+			problems, err = unknownYAMLKeys(path, err)
+		}
+	case ".json":
+		problems, err = decodeJSONConfig(path, data, c)
+	default:
+		return nil, fmt.Errorf("%v: unknown configuration format, it must end in one of %v", path, strings.Join(configExtensions, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return problems, nil
+}
+
+// merge overlays a configuration with a higher precedence onto this one. Settings it has replace those in this
+// one, and load balancers, API tokens, webhooks and groups are replaced by name or added. Names repeated within the
+// same file are kept, for config validate to find.
+func (c *HAProxyCtlConfig) merge(o *HAProxyCtlConfig) {
+	overlay := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	overlay(&c.DefaultUsername, o.DefaultUsername)
+	overlay(&c.DefaultPassword, o.DefaultPassword)
+	overlay(&c.CredentialsFile, o.CredentialsFile)
+	overlay(&c.CredentialHelper, o.CredentialHelper)
+	overlay(&c.KeyFile, o.KeyFile)
+
+	lbs, tokens, webhooks, groups := len(c.LoadBalancers), len(c.APITokens), len(c.Webhooks), len(c.LoadBalancerGroups)
+
+LoadBalancers:
+	for _, lb := range o.LoadBalancers {
+		for i := range c.LoadBalancers[:lbs] {
+			if strings.EqualFold(c.LoadBalancers[i].Name, lb.Name) {
+				c.LoadBalancers[i] = lb
+				continue LoadBalancers
+			}
+		}
+		c.LoadBalancers = append(c.LoadBalancers, lb)
+	}
+
+APITokens:
+	for _, t := range o.APITokens {
+		for i := range c.APITokens[:tokens] {
+			if c.APITokens[i].Name == t.Name {
+				c.APITokens[i] = t
+				continue APITokens
+			}
+		}
+		c.APITokens = append(c.APITokens, t)
+	}
+
+Webhooks:
+	for _, w := range o.Webhooks {
+		for i := range c.Webhooks[:webhooks] {
+			if c.Webhooks[i].Name == w.Name {
+				c.Webhooks[i] = w
+				continue Webhooks
+			}
+		}
+		c.Webhooks = append(c.Webhooks, w)
+	}
+
+LoadBalancerGroups:
+	for _, g := range o.LoadBalancerGroups {
+		for i := range c.LoadBalancerGroups[:groups] {
+			if strings.EqualFold(c.LoadBalancerGroups[i].Name, g.Name) {
+				c.LoadBalancerGroups[i] = g
+				continue LoadBalancerGroups
+			}
+		}
+		c.LoadBalancerGroups = append(c.LoadBalancerGroups, g)
+	}
+}
+
+// redacted is shown in place of secrets
+const redacted = "REDACTED"
+
+// redactSecret hides a password or token, but still shows whether it is set and how it is encrypted
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	if isEncrypted(s) {
+		method := strings.SplitN(strings.TrimPrefix(s, secretPrefix), ":", 2)[0]
+		return secretPrefix + method + ":" + redacted
+	}
+	return redacted
+}
+
+// redactURL hides the password in a URL, and with path also its path and query, which some webhooks use as
+// their secret
+func redactURL(s string, path bool) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	if path && (u.Path != "" || u.RawQuery != "") {
+		u.Path, u.RawPath, u.RawQuery = "/"+redacted, "", ""
+	}
+	return u.String()
+}
+
+// redacted returns a copy of the configuration with its passwords, tokens and webhook URLs hidden. It has the load
+// balancers listed in the configuration, not those found in LoadBalancerGroups.
+func (c *HAProxyCtlConfig) redacted() *HAProxyCtlConfig {
+	r := *c
+	r.DefaultPassword = redactSecret(c.DefaultPassword)
+
+	lbs := c.LoadBalancers
+	if c.discovery != nil {
+		lbs = c.discovery.static
+	}
+	r.LoadBalancers = make([]LoadBalancer, len(lbs))
+	for i, lb := range lbs {
+		lb.Url = redactURL(lb.Url, false)
+		lb.Password = redactSecret(lb.Password)
+		r.LoadBalancers[i] = lb
+	}
+	r.APITokens = make([]APIToken, len(c.APITokens))
+	for i, t := range c.APITokens {
+		t.Token = redactSecret(t.Token)
+		r.APITokens[i] = t
+	}
+	r.Webhooks = make([]Webhook, len(c.Webhooks))
+	for i, w := range c.Webhooks {
+		w.Url = redactURL(w.Url, true)
+		r.Webhooks[i] = w
+	}
+	r.LoadBalancerGroups = make([]LoadBalancerGroup, len(c.LoadBalancerGroups))
+	for i, g := range c.LoadBalancerGroups {
+		g.Password = redactSecret(g.Password)
+		r.LoadBalancerGroups[i] = g
+	}
+	return &r
+}
+
+// runConfigShow prints the configuration as it is used, after the files in the search path are merged, with
+// its secrets redacted
+func runConfigShow(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandConfig + " show")
+	format := flags.String("format", "toml", "Format to show the configuration in: toml, yaml or json")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	r := c.redacted()
+	var out []byte
+	var err error
+	switch *format = strings.ToLower(*format); *format {
+	case "toml":
+		var buf bytes.Buffer
+		err = toml.NewEncoder(&buf).Encode(r)
+		out = buf.Bytes()
+	case "yaml", "yml":
+		out, err = yaml.Marshal(r)
+	case "json":
+		out, err = json.MarshalIndent(r, "", "  ")
+		out = append(out, '\n')
+	default:
+		return fmt.Errorf("unknown format %v, must be toml, yaml or json", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *format != "json" {
+		for _, f := range c.files {
+			fmt.Printf("# From %v\n", f)
+		}
+	}
+	os.Stdout.Write(out)
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// credential is a username and password, and where each came from
+type credential struct {
+	Username string
+	Password string
+
+	usernameSource string
+	passwordSource string
+}
+
+// complete returns true once both the username and password are known
+func (c *credential) complete() bool {
+	return c.Username != "" && c.Password != ""
+}
+
+// fill sets the username and password from a source, if they are not already known
+func (c *credential) fill(username, password, source string) {
+	if c.Username == "" && username != "" {
+		c.Username, c.usernameSource = username, source
+	}
+	if c.Password == "" && password != "" {
+		c.Password, c.passwordSource = password, source
+	}
+}
+
+// credentialSources finds the credentials for load balancers. See resolve for the order they are looked in.
+type credentialSources struct {
+	config *HAProxyCtlConfig
+	file   map[string]credential // Sections of the credentials file, by load balancer name or "default"
+	netrc  []netrcEntry
+}
+
+// newCredentialSources reads the credentials file and ~/.netrc, if they exist
+func newCredentialSources(c *HAProxyCtlConfig) (*credentialSources, error) {
+	s := &credentialSources{config: c}
+
+	path := c.CredentialsFile
+	if env := os.Getenv("HAPROXYCTL_CREDENTIALS"); env != "" {
+		path = env
+	}
+	if path != "" {
+		var err error
+		if s.file, err = readCredentialsFile(expandHome(path)); err != nil {
+			return nil, err
+		}
+	}
+
+	netrcPath := os.Getenv("NETRC")
+	if netrcPath == "" {
+		netrcPath = expandHome("~/.netrc")
+	}
+	if data, err := ioutil.ReadFile(netrcPath); err == nil {
+		s.netrc = parseNetrc(data)
+	}
+
+	return s, nil
+}
+
+// resolve finds the username and password for a load balancer. Each is taken from the first of these that has
+// it, and any credentials in the URL are removed from it so that they cannot appear in errors:
+//
+//  1. $HAPROXYCTL_<NAME>_USERNAME and $HAPROXYCTL_<NAME>_PASSWORD, where NAME is the load balancer's name
+//  2. Username and Password of the load balancer in the configuration file, or user:password@ in its Url
+//  3. The load balancer's section of the credentials file
+//  4. The ~/.netrc machine matching the load balancer's host
+//  5. The CredentialHelper command
+//  6. $HAPROXYCTL_USERNAME and $HAPROXYCTL_PASSWORD
+//  7. The [default] section of the credentials file
+//  8. DefaultUsername and DefaultPassword in the configuration file
+func (s *credentialSources) resolve(lb *LoadBalancer, u *url.URL) (credential, error) {
+	var cred credential
+
+	prefix := "HAPROXYCTL_" + envName(lb.Name) + "_"
+	cred.fill(os.Getenv(prefix+"USERNAME"), os.Getenv(prefix+"PASSWORD"), "environment ("+prefix+"*)")
+	cred.fill(lb.Username, lb.Password, "configuration file")
+	if u.User != nil {
+		password, _ := u.User.Password()
+		cred.fill(u.User.Username(), password, "configuration file (Url)")
+		u.User = nil
+	}
+	if f, ok := s.file[lb.Name]; ok {
+		cred.fill(f.Username, f.Password, "credentials file")
+	}
+	if !cred.complete() {
+		if e, ok := s.netrcEntry(u.Hostname()); ok {
+			cred.fill(e.login, e.password, "netrc")
+		}
+	}
+	if !cred.complete() && s.config.CredentialHelper != "" {
+		username, password, err := runCredentialHelper(s.config.CredentialHelper, u, cred.Username)
+		if err != nil {
+			return cred, err
+		}
+		cred.fill(username, password, "credential helper")
+	}
+	cred.fill(os.Getenv("HAPROXYCTL_USERNAME"), os.Getenv("HAPROXYCTL_PASSWORD"), "environment (HAPROXYCTL_*)")
+	if f, ok := s.file["default"]; ok {
+		cred.fill(f.Username, f.Password, "credentials file (default)")
+	}
+	cred.fill(s.config.DefaultUsername, s.config.DefaultPassword, "configuration file (default)")
+
+	return cred, nil
+}
+
+// netrcEntry finds the ~/.netrc machine for a host
+func (s *credentialSources) netrcEntry(host string) (netrcEntry, bool) {
+	for _, e := range s.netrc {
+		if strings.EqualFold(e.machine, host) {
+			return e, true
+		}
+	}
+	return netrcEntry{}, false
+}
+
+var envNameInvalid = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// envName turns a load balancer name such as ny-lb01 into the NY_LB01 used in environment variables
+func envName(name string) string {
+	return envNameInvalid.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
+// expandHome replaces a leading ~ in a path with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// readCredentialsFile reads a TOML file of [LB01] sections with a Username and Password, and an optional
+// [default] section. The file must not be readable or writable by anyone but its owner.
+func readCredentialsFile(path string) (map[string]credential, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("credentials file: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("credentials file %v can be read or written by other users (mode %v), it must be chmod 600", path, info.Mode().Perm())
+	}
+
+	creds := make(map[string]credential)
+	if _, err := toml.DecodeFile(path, &creds); err != nil {
+		return nil, fmt.Errorf("credentials file %v: %v", path, err)
+	}
+	return creds, nil
+}
+
+// netrcEntry is a machine in a .netrc file
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc reads the machines in a .netrc file. Macros and the default entry are ignored.
+func parseNetrc(data []byte) []netrcEntry {
+	var entries []netrcEntry
+	var current *netrcEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			//Macros run until the next blank line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			next := ""
+			if i+1 < len(fields) {
+				next = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: next})
+				current = &entries[len(entries)-1]
+				i++
+			case "default":
+				current = nil
+			case "login":
+				if current != nil {
+					current.login = next
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.password = next
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	return entries
+}
+
+// runCredentialHelper asks a git-credential style helper for a username and password. The helper is run
+// through the shell with "get" appended, is given the protocol, host, path and any known username on stdin,
+// and prints username= and password= lines.
+func runCredentialHelper(helper string, u *url.URL, username string) (string, string, error) {
+	var input bytes.Buffer
+	fmt.Fprintf(&input, "protocol=%v\nhost=%v\n", u.Scheme, u.Host)
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		fmt.Fprintf(&input, "path=%v\n", path)
+	}
+	if username != "" {
+		fmt.Fprintf(&input, "username=%v\n", username)
+	}
+	input.WriteString("\n")
+
+	cmd := exec.Command("sh", "-c", helper+" get")
+	cmd.Stdin = &input
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		//The output is not included, as it may hold a password
+		return "", "", fmt.Errorf("credential helper failed: %v", err)
+	}
+
+	var user, password string
+	for _, line := range strings.Split(string(output), "\n") {
+		kv := strings.SplitN(strings.TrimRight(line, "\r"), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			user = kv[1]
+		case "password":
+			password = kv[1]
+		}
+	}
+	return user, password, nil
+}
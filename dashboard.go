@@ -0,0 +1,180 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// dashboardAssets are the files of the web dashboard, built into the binary
+//
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+// dashboard is the state of every backend on every load balancer, as shown by the web dashboard
+type dashboard struct {
+	User          string             `json:"user"` // Name of the token the dashboard was given
+	LoadBalancers []dashboardLB      `json:"loadbalancers"`
+	Backends      []dashboardBackend `json:"backends"`
+}
+
+// dashboardLB is a load balancer, and the error from getting its statistics if there was one
+type dashboardLB struct {
+	Name  string      `json:"name"`
+	Error interface{} `json:"error"`
+}
+
+// dashboardBackend is a backend and its servers, side-by-side across the load balancers
+type dashboardBackend struct {
+	Name    string                  `json:"name"`
+	State   haproxyctl.BackendState `json:"state"`
+	Status  map[string]string       `json:"status"` // Status of the backend itself, by load balancer
+	Servers []dashboardServer       `json:"servers"`
+	Actions []string                `json:"actions"` // Actions the dashboard's token may send to the backend
+}
+
+// dashboardServer is a server's state on each load balancer. Drift is set when the load balancers disagree about
+// its status or weight, or it is missing from some of them.
+type dashboardServer struct {
+	Name  string                    `json:"name"`
+	Drift bool                      `json:"drift"`
+	LBs   map[string]*dashboardCell `json:"lbs"`
+}
+
+// dashboardCell is a server on a single load balancer
+type dashboardCell struct {
+	Status          string `json:"status"`
+	Weight          uint64 `json:"weight"`
+	CheckStatus     string `json:"check_status"`
+	SessionsCurrent uint64 `json:"scur"`
+}
+
+// buildDashboard lays out the statistics of every load balancer side-by-side per backend and server
+func buildDashboard(stats []haproxyctl.FleetStats) *dashboard {
+	d := &dashboard{}
+	backends := make(map[string]*dashboardBackend)
+	servers := make(map[string]int) // Index of each backend/server in its backend's Servers
+	var order []string
+
+	for _, l := range stats {
+		d.LoadBalancers = append(d.LoadBalancers, dashboardLB{Name: l.Name, Error: errorValue(l.Err)})
+		if l.Err != nil {
+			continue
+		}
+		for _, s := range *l.Stats {
+			if s.Type != haproxyctl.Server && s.Type != haproxyctl.Backend {
+				continue
+			}
+			b, ok := backends[s.BackendName]
+			if !ok {
+				b = &dashboardBackend{Name: s.BackendName, Status: make(map[string]string)}
+				backends[s.BackendName] = b
+				order = append(order, s.BackendName)
+			}
+			if s.Type == haproxyctl.Backend {
+				b.Status[l.Name] = s.Status
+				continue
+			}
+
+			i, ok := servers[s.BackendName+"/"+s.FrontendName]
+			if !ok {
+				i = len(b.Servers)
+				servers[s.BackendName+"/"+s.FrontendName] = i
+				b.Servers = append(b.Servers, dashboardServer{Name: s.FrontendName, LBs: make(map[string]*dashboardCell)})
+			}
+			b.Servers[i].LBs[l.Name] = &dashboardCell{
+				Status:          s.Status,
+				Weight:          s.Weight,
+				CheckStatus:     s.CheckStatus,
+				SessionsCurrent: s.SessionsCurrent,
+			}
+		}
+	}
+
+	state := make(map[string]haproxyctl.BackendState)
+	for _, s := range haproxyctl.Summarize(stats) {
+		state[s.Backend] = s.State
+	}
+
+	up := 0
+	for _, l := range stats {
+		if l.Err == nil {
+			up++
+		}
+	}
+	for _, name := range order {
+		b := backends[name]
+		b.State = state[name]
+		for i := range b.Servers {
+			b.Servers[i].Drift = drifted(b.Servers[i].LBs, up)
+		}
+		d.Backends = append(d.Backends, *b)
+	}
+	sort.SliceStable(d.Backends, func(i, j int) bool {
+		return strings.ToLower(d.Backends[i].Name) < strings.ToLower(d.Backends[j].Name)
+	})
+	return d
+}
+
+// drifted returns true if a server is not on every load balancer that answered, or they disagree about its
+// status or weight
+func drifted(cells map[string]*dashboardCell, lbs int) bool {
+	if len(cells) != lbs {
+		return true
+	}
+	var first *dashboardCell
+	for _, c := range cells {
+		if first == nil {
+			first = c
+			continue
+		}
+		if c.Status != first.Status || c.Weight != first.Weight {
+			return true
+		}
+	}
+	return false
+}
+
+// serveDashboardData serves the state of every load balancer for the dashboard, with the backends the token may see
+func (s *apiServer) serveDashboardData(w http.ResponseWriter, r *http.Request, t *APIToken) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%v is not allowed", r.Method))
+		return
+	}
+
+	d := buildDashboard(s.config.fleet().GetStats())
+	d.User = t.Name
+	backends := d.Backends[:0]
+	for _, b := range d.Backends {
+		if !t.seesBackend(b.Name) {
+			continue
+		}
+		b.Actions = []string{}
+		if t.allowsBackend(b.Name) {
+			for _, a := range t.Actions {
+				b.Actions = append(b.Actions, strings.ToLower(a))
+			}
+		}
+		backends = append(backends, b)
+	}
+	d.Backends = backends
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// dashboardHandler serves the dashboard's files. They need no token; the dashboard asks for one and sends it
+// with each API request.
+func dashboardHandler() http.Handler {
+	root, err := fs.Sub(dashboardAssets, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
@@ -0,0 +1,197 @@
+(function () {
+	"use strict";
+
+	var refreshInterval = 5000;
+	var token = sessionStorage.getItem("haproxyctl-token");
+	var latest = null;
+	var timer = null;
+
+	function $(id) {
+		return document.getElementById(id);
+	}
+
+	function element(tag, className, text) {
+		var e = document.createElement(tag);
+		if (className) {
+			e.className = className;
+		}
+		if (text !== undefined) {
+			e.textContent = text;
+		}
+		return e;
+	}
+
+	function showMessage(text) {
+		$("message").textContent = text;
+		$("message").hidden = !text;
+	}
+
+	function api(method, path, body) {
+		var options = {method: method, headers: {"Authorization": "Bearer " + token}};
+		if (body) {
+			options.headers["Content-Type"] = "application/json";
+			options.body = JSON.stringify(body);
+		}
+		return fetch(path, options).then(function (response) {
+			if (response.status === 401) {
+				signOut();
+				throw new Error("The token was not accepted");
+			}
+			return response.json().then(function (data) {
+				if (!response.ok) {
+					throw new Error(data.error || response.statusText);
+				}
+				return data;
+			});
+		});
+	}
+
+	// statusClass reduces a HAProxy status such as "UP 1/3" or "MAINT (via x/y)" to a class for colouring
+	function statusClass(status) {
+		if (status === undefined) {
+			return "status-missing";
+		}
+		if (status === "no check") {
+			return "status-up";
+		}
+		return "status-" + status.split(" ")[0].toLowerCase();
+	}
+
+	function matches(text) {
+		var filter = $("filter").value.trim().toLowerCase();
+		return !filter || text.toLowerCase().indexOf(filter) !== -1;
+	}
+
+	function render() {
+		var d = latest;
+		if (!d) {
+			return;
+		}
+		$("user").textContent = "Signed in as " + d.user;
+
+		var lbs = $("loadbalancers");
+		lbs.textContent = "";
+		d.loadbalancers.forEach(function (lb) {
+			if (lb.error) {
+				lbs.appendChild(element("div", "lb-error", lb.name + ": " + lb.error));
+			}
+		});
+
+		var driftOnly = $("drift-only").checked;
+		var container = $("backends");
+		container.textContent = "";
+
+		d.backends.forEach(function (b) {
+			var servers = b.servers.filter(function (s) {
+				return (!driftOnly || s.drift) && (matches(b.name) || matches(s.name));
+			});
+			if (servers.length === 0) {
+				return;
+			}
+
+			var table = element("table", "state-" + b.state);
+			var head = element("tr");
+			var title = element("th", "backend", b.name + " (" + b.state + ")");
+			head.appendChild(title);
+			d.loadbalancers.forEach(function (lb) {
+				head.appendChild(element("th", "", lb.name + (b.status[lb.name] ? ": " + b.status[lb.name] : "")));
+			});
+			if (b.actions.length > 0) {
+				head.appendChild(element("th", "", "Actions"));
+			}
+			table.appendChild(head);
+
+			servers.forEach(function (s) {
+				var row = element("tr", s.drift ? "drift" : "");
+				row.appendChild(element("td", "server", s.name));
+				d.loadbalancers.forEach(function (lb) {
+					var cell = s.lbs[lb.name];
+					if (!cell) {
+						row.appendChild(element("td", "status-missing", lb.error ? "?" : "missing"));
+						return;
+					}
+					var td = element("td", statusClass(cell.status), cell.status);
+					td.appendChild(element("div", "detail",
+						"weight " + cell.weight + ", " + cell.scur + " sessions" + (cell.check_status ? ", " + cell.check_status : "")));
+					row.appendChild(td);
+				});
+				if (b.actions.length > 0) {
+					var actions = element("td", "actions");
+					b.actions.forEach(function (action) {
+						var button = element("button", "", action);
+						button.addEventListener("click", function () {
+							sendAction(action, b.name, s.name);
+						});
+						actions.appendChild(button);
+					});
+					row.appendChild(actions);
+				}
+				table.appendChild(row);
+			});
+			container.appendChild(table);
+		});
+	}
+
+	function sendAction(action, backend, server) {
+		if (!confirm("Send '" + action + "' to " + server + " in " + backend + " on every load balancer?")) {
+			return;
+		}
+		api("POST", "api/v1/actions", {action: action, servers: [server], backend: backend}).then(function (results) {
+			var failed = results.filter(function (r) {
+				return r.error || !r.allok;
+			}).map(function (r) {
+				return r.lb + ": " + (r.error || "not every server was changed");
+			});
+			showMessage(failed.length ? "Some load balancers failed: " + failed.join("; ") : "");
+			refresh();
+		}).catch(function (err) {
+			showMessage(err.message);
+		});
+	}
+
+	function refresh() {
+		clearTimeout(timer);
+		if (!token) {
+			return;
+		}
+		api("GET", "api/v1/dashboard").then(function (d) {
+			latest = d;
+			render();
+		}).catch(function (err) {
+			showMessage(err.message);
+		}).then(function () {
+			if (token) {
+				timer = setTimeout(refresh, refreshInterval);
+			}
+		});
+	}
+
+	function signOut() {
+		token = null;
+		latest = null;
+		sessionStorage.removeItem("haproxyctl-token");
+		$("backends").textContent = "";
+		$("loadbalancers").textContent = "";
+		$("user").textContent = "";
+		$("login").hidden = false;
+	}
+
+	$("login").addEventListener("submit", function (e) {
+		e.preventDefault();
+		token = $("token").value.trim();
+		$("token").value = "";
+		sessionStorage.setItem("haproxyctl-token", token);
+		$("login").hidden = true;
+		showMessage("");
+		refresh();
+	});
+	$("logout").addEventListener("click", signOut);
+	$("filter").addEventListener("input", render);
+	$("drift-only").addEventListener("change", render);
+
+	if (token) {
+		refresh();
+	} else {
+		signOut();
+	}
+})();
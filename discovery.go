@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LoadBalancerGroup is a group of load balancers that are found when haproxyctl runs, from a DNS SRV record or an
+// inventory, rather than listed in the configuration. Each load balancer found is in the group, and has its
+// credentials and tags unless it has its own.
+type LoadBalancerGroup struct {
+	Name      string            `yaml:"Name"`      // Added to the Groups of every load balancer found, for -lb
+	SRV       string            `yaml:"SRV"`       // DNS SRV record to find the load balancers with, such as _haproxy._tcp.example.com
+	Resolver  string            `yaml:"Resolver"`  // DNS server (host:port) to look the SRV record up with, instead of the system's
+	Inventory string            `yaml:"Inventory"` // JSON or YAML list of load balancers, or a directory with a file for each
+	Scheme    string            `yaml:"Scheme"`    // Scheme of the URLs made from SRV records and host names (default http)
+	Port      int               `yaml:"Port"`      // Port of the URLs made from host names in an inventory that have none
+	Path      string            `yaml:"Path"`      // Path of the URLs made from SRV records and host names (default /)
+	Username  string            `yaml:"Username"`
+	Password  string            `yaml:"Password"`
+	Tags      map[string]string `yaml:"Tags"`
+	Refresh   configDuration    `yaml:"Refresh"` // How often commands that keep running look for load balancers again (default 1m)
+}
+
+const (
+	defaultDiscoveryRefresh = time.Minute
+	discoveryTimeout        = 10 * time.Second
+)
+
+// discovery is the state of the load balancer groups while haproxyctl runs. It is shared by every copy of the
+// configuration, and guarded by discoveryLock, apart from static and selector, which are set before it is used.
+type discovery struct {
+	static     []LoadBalancer            // The load balancers listed in the configuration
+	found      map[string][]LoadBalancer // The load balancers last found in each group
+	selector   *lbSelector               // The -lb and -select the load balancers are limited to
+	next       time.Time                 // When to look for load balancers again
+	refreshing bool                      // Set while refreshDiscovery is looking for load balancers
+}
+
+// discoveryLock guards the discovery state, and the load balancers of a configuration with groups, which are
+// replaced as load balancers are found
+var discoveryLock sync.Mutex
+
+// discover adds the load balancers found in every group to the configuration. Groups that cannot be read are
+// recorded as problems, so that commands stop with a clear error and config validate lists them.
+func (c *HAProxyCtlConfig) discover() {
+	if len(c.LoadBalancerGroups) == 0 {
+		return
+	}
+	c.discovery = &discovery{
+		static: c.LoadBalancers,
+		found:  make(map[string][]LoadBalancer),
+		next:   time.Now().Add(c.discoveryRefresh()),
+	}
+	for i, g := range c.LoadBalancerGroups {
+		lbs, err := g.find()
+		if err != nil {
+			c.problems = append(c.problems, configProblem{Severity: severityError, Check: checkDiscovery, Field: fmt.Sprintf("LoadBalancerGroups[%v]", i), Message: fmt.Sprintf("group %v: %v", g.Name, err)})
+			continue
+		}
+		c.discovery.found[g.Name] = lbs
+	}
+	c.LoadBalancers = c.discovery.loadBalancers(c.LoadBalancerGroups)
+}
+
+// discoveryRefresh returns how often to look for load balancers again, which is the shortest Refresh of any group
+func (c *HAProxyCtlConfig) discoveryRefresh() time.Duration {
+	refresh := time.Duration(0)
+	for _, g := range c.LoadBalancerGroups {
+		if g.Refresh.Duration > 0 && (refresh == 0 || g.Refresh.Duration < refresh) {
+			refresh = g.Refresh.Duration
+		}
+	}
+	if refresh == 0 {
+		refresh = defaultDiscoveryRefresh
+	}
+	return refresh
+}
+
+// loadBalancers returns the load balancers in the configuration, followed by those found in each group that
+// do not have the same name as one before them
+func (d *discovery) loadBalancers(groups []LoadBalancerGroup) []LoadBalancer {
+	lbs := append([]LoadBalancer{}, d.static...)
+	seen := make(map[string]bool)
+	for _, lb := range lbs {
+		seen[strings.ToLower(lb.Name)] = true
+	}
+	for _, g := range groups {
+		for _, lb := range d.found[g.Name] {
+			if !seen[strings.ToLower(lb.Name)] {
+				seen[strings.ToLower(lb.Name)] = true
+				lbs = append(lbs, lb)
+			}
+		}
+	}
+	return lbs
+}
+
+// refreshDiscovery looks for the load balancers in every group again, once the refresh interval has passed. Load
+// balancers that are still there are kept as they are, new ones have their credentials found, and any that
+// cannot be used are logged and left out. A group that cannot be read keeps the load balancers last found in it.
+// The lock is not held while looking, as SRV lookups and credential helpers can be slow, and only one caller
+// looks at a time.
+func (c *HAProxyCtlConfig) refreshDiscovery() {
+	if c.discovery == nil {
+		return
+	}
+	discoveryLock.Lock()
+	d := c.discovery
+	if d.refreshing || time.Now().Before(d.next) {
+		discoveryLock.Unlock()
+		return
+	}
+	d.refreshing = true
+	previous := c.LoadBalancers
+	found := make(map[string][]LoadBalancer)
+	for name, lbs := range d.found {
+		found[name] = lbs
+	}
+	discoveryLock.Unlock()
+
+	lbs, ok := c.rediscover(d, previous, found)
+
+	discoveryLock.Lock()
+	defer discoveryLock.Unlock()
+	d.found = found
+	if ok {
+		c.LoadBalancers = lbs
+	}
+	d.refreshing = false
+	d.next = time.Now().Add(c.discoveryRefresh())
+}
+
+// rediscover updates found with the load balancers in every group, and returns the load balancers to use in
+// place of previous. It returns false if they cannot be worked out, and previous should be kept.
+func (c *HAProxyCtlConfig) rediscover(d *discovery, previous []LoadBalancer, found map[string][]LoadBalancer) ([]LoadBalancer, bool) {
+	for _, g := range c.LoadBalancerGroups {
+		lbs, err := g.find()
+		if err != nil {
+			log.Printf("Cannot find the load balancers in group %v, keeping the %v found before: %v", g.Name, len(found[g.Name]), err)
+			continue
+		}
+		found[g.Name] = lbs
+	}
+
+	current := make(map[string]*LoadBalancer)
+	for i := range previous {
+		current[strings.ToLower(previous[i].Name)] = &previous[i]
+	}
+
+	sources, err := newCredentialSources(c)
+	if err != nil {
+		log.Printf("Cannot add the load balancers found: %v", err)
+		return nil, false
+	}
+	keys := newSecretKeys(c)
+
+	var lbs []LoadBalancer
+	all := (&discovery{static: d.static, found: found}).loadBalancers(c.LoadBalancerGroups)
+	for _, lb := range all {
+		if d.selector != nil && !d.selector.Match(&lb) {
+			continue
+		}
+		if old, ok := current[strings.ToLower(lb.Name)]; ok && old.Url == lb.Url {
+			lbs = append(lbs, *old)
+			delete(current, strings.ToLower(lb.Name))
+			continue
+		}
+		if errs := lb.init(sources, keys).errors(); len(errs) > 0 {
+			log.Printf("Cannot add load balancer %v: %v", lb.Name, errs[0].Message)
+			continue
+		}
+		log.Printf("Found load balancer %v at %v", lb.Name, lb.HAProxyCtl.URL.Host)
+		lbs = append(lbs, lb)
+	}
+	for _, old := range current {
+		log.Printf("Load balancer %v has gone", old.Name)
+	}
+	return lbs, true
+}
+
+// loadBalancers returns the current load balancers, including those found in LoadBalancerGroups. Once
+// discovery is running this is the only safe way to read them, as refreshDiscovery replaces c.LoadBalancers. The
+// slice returned is never changed, so it can be used without the lock.
+func (c *HAProxyCtlConfig) loadBalancers() []LoadBalancer {
+	c.refreshDiscovery()
+	discoveryLock.Lock()
+	defer discoveryLock.Unlock()
+	return c.LoadBalancers
+}
+
+// find looks up the load balancers in the group
+func (g *LoadBalancerGroup) find() ([]LoadBalancer, error) {
+	var hosts []LoadBalancer
+	var err error
+	switch {
+	case g.SRV != "" && g.Inventory != "":
+		return nil, fmt.Errorf("a group can have an SRV record or an inventory, but not both")
+	case g.SRV != "":
+		hosts, err = g.lookupSRV()
+	case g.Inventory != "":
+		hosts, err = g.readInventory()
+	default:
+		return nil, fmt.Errorf("a group needs an SRV record or an inventory")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lbs := make([]LoadBalancer, len(hosts))
+	for i, h := range hosts {
+		lbs[i] = g.inherit(h)
+	}
+	return lbs, nil
+}
+
+// inherit gives a load balancer found in the group the group's credentials, tags and name, unless it has its own
+func (g *LoadBalancerGroup) inherit(lb LoadBalancer) LoadBalancer {
+	if lb.Username == "" {
+		lb.Username = g.Username
+	}
+	if lb.Password == "" {
+		lb.Password = g.Password
+	}
+	tags := make(map[string]string)
+	for k, v := range g.Tags {
+		tags[k] = v
+	}
+	for k, v := range lb.Tags {
+		tags[k] = v
+	}
+	lb.Tags = tags
+	if g.Name != "" {
+		lb.Groups = append(append([]string{}, lb.Groups...), g.Name)
+	}
+	return lb
+}
+
+// url makes the URL of a load balancer's stats page from its host and port
+func (g *LoadBalancerGroup) url(host string, port int) string {
+	scheme, path := g.Scheme, g.Path
+	if scheme == "" {
+		scheme = "http"
+	}
+	if path == "" {
+		path = "/"
+	}
+	if port != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	return (&url.URL{Scheme: scheme, Host: host, Path: path}).String()
+}
+
+// lookupSRV finds the load balancers in the group's SRV record, named after their hosts
+func (g *LoadBalancerGroup) lookupSRV() ([]LoadBalancer, error) {
+	resolver := net.DefaultResolver
+	if g.Resolver != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, g.Resolver)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	_, records, err := resolver.LookupSRV(ctx, "", "", g.SRV)
+	if err != nil {
+		return nil, err
+	}
+
+	var lbs []LoadBalancer
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		lbs = append(lbs, LoadBalancer{Name: host, Url: g.url(host, int(r.Port))})
+	}
+	return lbs, nil
+}
+
+// inventoryHost is a load balancer in an inventory. It can be written like a [[LoadBalancers]] entry, or as just a
+// URL, or a host name with an optional port.
+type inventoryHost struct {
+	LoadBalancer
+	address string
+}
+
+func (h *inventoryHost) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&h.address); err == nil {
+		return nil
+	}
+	return unmarshal(&h.LoadBalancer)
+}
+
+func (h *inventoryHost) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &h.address); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &h.LoadBalancer)
+}
+
+// loadBalancer turns an inventory host into a load balancer, using name if it does not have one
+func (g *LoadBalancerGroup) loadBalancer(h inventoryHost, name string) (LoadBalancer, error) {
+	lb := h.LoadBalancer
+	address := strings.TrimSpace(h.address)
+	switch {
+	case strings.Contains(address, "://"):
+		lb.Url = address
+	case address != "":
+		host, port := address, g.Port
+		if hostname, p, err := net.SplitHostPort(address); err == nil {
+			host = hostname
+			if port, err = strconv.Atoi(p); err != nil {
+				return lb, fmt.Errorf("%v has an invalid port", address)
+			}
+		}
+		if port == 0 {
+			return lb, fmt.Errorf("%v has no port, and the group has no Port", address)
+		}
+		lb.Url = g.url(host, port)
+		if name == "" {
+			name = host
+		}
+	}
+
+	if lb.Name == "" {
+		lb.Name = name
+	}
+	if lb.Name == "" {
+		if u, err := url.Parse(lb.Url); err == nil {
+			lb.Name = u.Hostname()
+		}
+	}
+	if lb.Name == "" || lb.Url == "" {
+		return lb, fmt.Errorf("every load balancer needs a Url, or a host name")
+	}
+	return lb, nil
+}
+
+// readInventory reads the group's inventory. A file is a JSON or YAML list of load balancers. A directory has a
+// file for each load balancer, named after it, with a JSON or YAML entry, or just its URL or host name. An empty
+// file is taken as the host name being the file's name.
+func (g *LoadBalancerGroup) readInventory() ([]LoadBalancer, error) {
+	path := expandHome(g.Inventory)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var hosts []inventoryHost
+		if err := decodeInventory(path, data, &hosts); err != nil {
+			return nil, err
+		}
+		var lbs []LoadBalancer
+		for i, h := range hosts {
+			lb, err := g.loadBalancer(h, "")
+			if err != nil {
+				return nil, fmt.Errorf("%v: entry %v: %v", path, i+1, err)
+			}
+			lbs = append(lbs, lb)
+		}
+		return lbs, nil
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	var lbs []LoadBalancer
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || strings.HasSuffix(f.Name(), "~") {
+			continue
+		}
+		file := filepath.Join(path, f.Name())
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		name := f.Name()
+		var h inventoryHost
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".json", ".yaml", ".yml":
+			if err := decodeInventory(file, data, &h); err != nil {
+				return nil, err
+			}
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		default:
+			if h.address = strings.TrimSpace(string(data)); h.address == "" {
+				h.address = name
+			}
+		}
+		lb, err := g.loadBalancer(h, name)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		lbs = append(lbs, lb)
+	}
+	return lbs, nil
+}
+
+// decodeInventory decodes an inventory file as JSON or YAML, depending on its extension
+func decodeInventory(path string, data []byte, v interface{}) error {
+	var err error
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(data, v)
+	} else {
+		err = yaml.Unmarshal(data, v)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeInventory(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// lbSummary is what the tests check of each load balancer found
+type lbSummary struct {
+	Name, Url, Username, Password string
+	Groups                        []string
+	Tags                          map[string]string
+}
+
+func summarizeLBs(lbs []LoadBalancer) []lbSummary {
+	var s []lbSummary
+	for _, lb := range lbs {
+		s = append(s, lbSummary{lb.Name, lb.Url, lb.Username, lb.Password, lb.Groups, lb.Tags})
+	}
+	return s
+}
+
+func testGroup(inventory string) LoadBalancerGroup {
+	return LoadBalancerGroup{
+		Name:      "ny",
+		Inventory: inventory,
+		Port:      7000,
+		Username:  "admin",
+		Password:  "group-password",
+		Tags:      map[string]string{"dc": "ny", "env": "prod"},
+	}
+}
+
+func TestDiscoverInventoryFile(t *testing.T) {
+	dir := t.TempDir()
+	writeInventory(t, dir, map[string]string{
+		"lbs.yaml": `
+- ny-lb01
+- ny-lb02:7001
+- https://ny-lb03.example.com/stats/
+- Name: ny-lb04
+  Url: http://10.0.0.4:7000/
+  Username: lb04
+  Password: own-password
+  Tags: {env: dev}
+`,
+		"lbs.json": `["ny-lb01", {"Name": "ny-lb04", "Url": "http://10.0.0.4:7000/", "Groups": ["edge"]}]`,
+	})
+
+	want := []lbSummary{
+		{"ny-lb01", "http://ny-lb01:7000/", "admin", "group-password", []string{"ny"}, map[string]string{"dc": "ny", "env": "prod"}},
+		{"ny-lb02", "http://ny-lb02:7001/", "admin", "group-password", []string{"ny"}, map[string]string{"dc": "ny", "env": "prod"}},
+		{"ny-lb03.example.com", "https://ny-lb03.example.com/stats/", "admin", "group-password", []string{"ny"}, map[string]string{"dc": "ny", "env": "prod"}},
+		//A load balancer's own credentials and tags win over the group's
+		{"ny-lb04", "http://10.0.0.4:7000/", "lb04", "own-password", []string{"ny"}, map[string]string{"dc": "ny", "env": "dev"}},
+	}
+	g := testGroup(filepath.Join(dir, "lbs.yaml"))
+	lbs, err := g.find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summarizeLBs(lbs); !reflect.DeepEqual(got, want) {
+		t.Errorf("yaml: got %+v, want %+v", got, want)
+	}
+
+	want = []lbSummary{
+		{"ny-lb01", "http://ny-lb01:7000/", "admin", "group-password", []string{"ny"}, map[string]string{"dc": "ny", "env": "prod"}},
+		{"ny-lb04", "http://10.0.0.4:7000/", "admin", "group-password", []string{"edge", "ny"}, map[string]string{"dc": "ny", "env": "prod"}},
+	}
+	g = testGroup(filepath.Join(dir, "lbs.json"))
+	if lbs, err = g.find(); err != nil {
+		t.Fatal(err)
+	}
+	if got := summarizeLBs(lbs); !reflect.DeepEqual(got, want) {
+		t.Errorf("json: got %+v, want %+v", got, want)
+	}
+}
+
+func TestDiscoverInventoryDir(t *testing.T) {
+	dir := t.TempDir()
+	writeInventory(t, dir, map[string]string{
+		"ny-lb01":      "",
+		"ny-lb02":      "10.0.0.2:7001\n",
+		"ny-lb03.yaml": "Url: http://10.0.0.3:7000/\nTags: {rack: r3}\n",
+		"ny-lb04.json": `"https://10.0.0.4/"`,
+		".hidden":      "ignored",
+		"ny-lb05~":     "ignored",
+	})
+
+	tags := map[string]string{"dc": "ny", "env": "prod"}
+	want := []lbSummary{
+		{"ny-lb01", "http://ny-lb01:7000/", "admin", "group-password", []string{"ny"}, tags},
+		{"ny-lb02", "http://10.0.0.2:7001/", "admin", "group-password", []string{"ny"}, tags},
+		{"ny-lb03", "http://10.0.0.3:7000/", "admin", "group-password", []string{"ny"}, map[string]string{"dc": "ny", "env": "prod", "rack": "r3"}},
+		{"ny-lb04", "https://10.0.0.4/", "admin", "group-password", []string{"ny"}, tags},
+	}
+	g := testGroup(dir)
+	lbs, err := g.find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summarizeLBs(lbs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDiscoverInventoryErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeInventory(t, dir, map[string]string{
+		"noport.yaml":  "- ny-lb01\n",
+		"badport.yaml": "- ny-lb01:http\n",
+		"nourl.yaml":   "- Name: ny-lb01\n",
+		"broken.json":  "[",
+	})
+	tests := []struct {
+		group LoadBalancerGroup
+		err   string
+	}{
+		{LoadBalancerGroup{Inventory: filepath.Join(dir, "noport.yaml")}, "has no port"},
+		{LoadBalancerGroup{Inventory: filepath.Join(dir, "badport.yaml")}, "invalid port"},
+		{LoadBalancerGroup{Inventory: filepath.Join(dir, "nourl.yaml")}, "needs a Url"},
+		{LoadBalancerGroup{Inventory: filepath.Join(dir, "broken.json")}, "broken.json"},
+		{LoadBalancerGroup{Inventory: filepath.Join(dir, "missing.yaml")}, "missing.yaml"},
+		{LoadBalancerGroup{Inventory: dir, SRV: "_haproxy._tcp.example.com"}, "not both"},
+		{LoadBalancerGroup{}, "needs an SRV record or an inventory"},
+	}
+	for _, tt := range tests {
+		_, err := tt.group.find()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: got %v, want an error containing %v", tt.group, err, tt.err)
+		}
+	}
+}
+
+// srvStub is a DNS server that answers every query with the same SRV records, after a delay
+type srvStub struct {
+	conn    net.PacketConn
+	delay   int64 // time.Duration, set with atomic
+	targets []string
+	port    uint16
+}
+
+func newSRVStub(t *testing.T, port uint16, targets ...string) *srvStub {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &srvStub{conn: conn, targets: targets, port: port}
+	go s.serve()
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+func (s *srvStub) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if reply := s.reply(buf[:n]); reply != nil {
+			time.Sleep(time.Duration(atomic.LoadInt64(&s.delay)))
+			s.conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// reply answers a query with the stub's SRV records, keeping its ID and question
+func (s *srvStub) reply(query []byte) []byte {
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+	if end > len(query) {
+		return nil
+	}
+
+	msg := append([]byte{}, query[:2]...)
+	msg = append(msg, 0x81, 0x80, 0, 1)
+	msg = appendUint16(msg, uint16(len(s.targets)))
+	msg = append(msg, 0, 0, 0, 0)
+	msg = append(msg, query[12:end]...)
+	for i, target := range s.targets {
+		var rdata []byte
+		//Each target has its own priority, so that they are looked up in order
+		rdata = appendUint16(rdata, uint16(10+i))
+		rdata = appendUint16(rdata, 0)
+		rdata = appendUint16(rdata, s.port)
+		for _, label := range strings.Split(strings.TrimSuffix(target, "."), ".") {
+			rdata = append(append(rdata, byte(len(label))), label...)
+		}
+		rdata = append(rdata, 0)
+
+		//The answer's name points back at the question's
+		msg = append(msg, 0xc0, 12, 0, 33, 0, 1, 0, 0, 0, 60)
+		msg = appendUint16(msg, uint16(len(rdata)))
+		msg = append(msg, rdata...)
+	}
+	return msg
+}
+
+func TestDiscoverSRV(t *testing.T) {
+	stub := newSRVStub(t, 7001, "ny-lb01.example.com.", "ny-lb02.example.com.")
+	g := LoadBalancerGroup{Name: "ny", SRV: "_haproxy._tcp.ny.example.com", Resolver: stub.conn.LocalAddr().String(), Path: "/stats", Username: "admin", Password: "pw"}
+
+	lbs, err := g.find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{}
+	want := []lbSummary{
+		{"ny-lb01.example.com", "http://ny-lb01.example.com:7001/stats", "admin", "pw", []string{"ny"}, tags},
+		{"ny-lb02.example.com", "http://ny-lb02.example.com:7001/stats", "admin", "pw", []string{"ny"}, tags},
+	}
+	if got := summarizeLBs(lbs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDiscoverRefresh(t *testing.T) {
+	os.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	writeInventory(t, dir, map[string]string{"ny-lb01": ""})
+	stub := newSRVStub(t, 7001, "la-lb01.example.com.")
+
+	c := &HAProxyCtlConfig{
+		LoadBalancers: []LoadBalancer{{Name: "static", Url: "http://10.0.0.1:7000/", Username: "admin", Password: "pw"}},
+		LoadBalancerGroups: []LoadBalancerGroup{
+			testGroup(dir),
+			{Name: "la", SRV: "_haproxy._tcp.la.example.com", Resolver: stub.conn.LocalAddr().String(), Username: "admin", Password: "pw"},
+		},
+	}
+	c.discover()
+	if errs := c.processInit().errors(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	names := func(lbs []LoadBalancer) []string {
+		var names []string
+		for _, lb := range lbs {
+			names = append(names, lb.Name)
+		}
+		return names
+	}
+	if got, want := names(c.loadBalancers()), []string{"static", "ny-lb01", "la-lb01.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	//A load balancer added to the inventory is found once the refresh is due, and the lock is not held while the
+	//slow SRV lookup is made, so other callers still get the load balancers found before
+	writeInventory(t, dir, map[string]string{"ny-lb02": ""})
+	atomic.StoreInt64(&stub.delay, int64(500*time.Millisecond))
+	discoveryLock.Lock()
+	c.discovery.next = time.Now()
+	discoveryLock.Unlock()
+
+	done := make(chan []LoadBalancer)
+	go func() { done <- c.loadBalancers() }()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	lbs := c.loadBalancers()
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("loadBalancers waited %v for the refresh", waited)
+	}
+	if got, want := names(lbs), []string{"static", "ny-lb01", "la-lb01.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("during the refresh, got %v, want %v", got, want)
+	}
+	if got, want := names(<-done), []string{"static", "ny-lb01", "ny-lb02", "la-lb01.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after the refresh, got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// runEvents prints every change in state on the load balancers as a line of JSON, until it is stopped
+func runEvents(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandEvents)
+	interval := flags.Duration("interval", 5*time.Second, "How often to poll the load balancers")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	out := json.NewEncoder(os.Stdout)
+	for e := range haproxyctl.WatchFleet(ctx, *interval, c.fleet) {
+		if err := out.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
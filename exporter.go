@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// metricType is a Prometheus metric type
+type metricType string
+
+const (
+	metricGauge   metricType = "gauge"
+	metricCounter metricType = "counter"
+)
+
+// statRows are the kinds of rows a statistic is reported on, as given for each statistic in HAProxy's
+// management guide. Listener sockets are counted as frontends.
+type statRows int
+
+const (
+	rowsFrontend statRows = 1 << iota
+	rowsBackend
+	rowsServer
+	rowsAll = rowsFrontend | rowsBackend | rowsServer
+)
+
+// has returns true if a statistic is reported on rows of this type
+func (r statRows) has(t haproxyctl.EntryType) bool {
+	switch t {
+	case haproxyctl.Frontend, haproxyctl.Socket:
+		return r&rowsFrontend != 0
+	case haproxyctl.Backend:
+		return r&rowsBackend != 0
+	}
+	return r&rowsServer != 0
+}
+
+// statMetric is a Prometheus metric taken from a single Statistic field
+type statMetric struct {
+	Name  string
+	Help  string
+	Type  metricType
+	Field string   // Go name of the Statistic field
+	Scale float64  // Multiplier to convert the field into the metric's unit, if not 1
+	Rows  statRows // Rows the statistic is reported on, as it is empty on the others
+}
+
+var statMetrics = []statMetric{
+	{"haproxy_current_sessions", "Current number of active sessions.", metricGauge, "SessionsCurrent", 0, rowsAll},
+	{"haproxy_max_sessions", "Maximum number of active sessions.", metricGauge, "SessionsMax", 0, rowsAll},
+	{"haproxy_limit_sessions", "Configured session limit.", metricGauge, "SessionLimit", 0, rowsAll},
+	{"haproxy_sessions_total", "Total number of sessions.", metricCounter, "SessionsTotal", 0, rowsAll},
+	{"haproxy_current_queue", "Current number of queued requests.", metricGauge, "QueueCurrent", 0, rowsBackend | rowsServer},
+	{"haproxy_max_queue", "Maximum number of queued requests.", metricGauge, "QueueMax", 0, rowsBackend | rowsServer},
+	{"haproxy_current_session_rate", "Number of sessions per second over the last second.", metricGauge, "Rate", 0, rowsAll},
+	{"haproxy_current_request_rate", "Number of HTTP requests per second over the last second.", metricGauge, "RequestRate", 0, rowsFrontend},
+	{"haproxy_bytes_in_total", "Total number of bytes received.", metricCounter, "BytesIn", 0, rowsAll},
+	{"haproxy_bytes_out_total", "Total number of bytes sent.", metricCounter, "BytesOut", 0, rowsAll},
+	{"haproxy_requests_denied_total", "Total number of denied requests.", metricCounter, "DeniedRequests", 0, rowsFrontend | rowsBackend},
+	{"haproxy_responses_denied_total", "Total number of denied responses.", metricCounter, "DeniedResponses", 0, rowsAll},
+	{"haproxy_request_errors_total", "Total number of request errors.", metricCounter, "ErrorsRequests", 0, rowsFrontend},
+	{"haproxy_connection_errors_total", "Total number of connection errors.", metricCounter, "ErrorsConnections", 0, rowsBackend | rowsServer},
+	{"haproxy_response_errors_total", "Total number of response errors.", metricCounter, "ErrorsResponses", 0, rowsBackend | rowsServer},
+	{"haproxy_retry_warnings_total", "Total number of connection retries.", metricCounter, "WarningsRetries", 0, rowsBackend | rowsServer},
+	{"haproxy_redispatch_warnings_total", "Total number of requests redispatched to another server.", metricCounter, "WarningsDispatches", 0, rowsBackend | rowsServer},
+	{"haproxy_client_aborts_total", "Total number of data transfers aborted by the client.", metricCounter, "AbortedByClient", 0, rowsBackend | rowsServer},
+	{"haproxy_server_aborts_total", "Total number of data transfers aborted by the server.", metricCounter, "AbortedByServer", 0, rowsBackend | rowsServer},
+	{"haproxy_http_requests_total", "Total number of HTTP requests.", metricCounter, "RequestTotal", 0, rowsFrontend | rowsBackend},
+	{"haproxy_server_selected_total", "Total number of times a server was selected.", metricCounter, "LBTotal", 0, rowsBackend | rowsServer},
+	{"haproxy_weight", "Current weight of the server, or total weight of the backend.", metricGauge, "Weight", 0, rowsBackend | rowsServer},
+	{"haproxy_check_failures_total", "Total number of failed health checks.", metricCounter, "CheckFailed", 0, rowsServer},
+	{"haproxy_check_down_transitions_total", "Total number of UP to DOWN transitions.", metricCounter, "CheckDowned", 0, rowsBackend | rowsServer},
+	{"haproxy_downtime_seconds_total", "Total downtime in seconds.", metricCounter, "Downtime", 0, rowsBackend | rowsServer},
+	{"haproxy_check_duration_seconds", "Duration of the last health check in seconds.", metricGauge, "CheckDuration", 0.001, rowsServer},
+	{"haproxy_queue_time_average_seconds", "Average queue time over the last 1024 requests.", metricGauge, "AvgQueueTime", 0.001, rowsBackend | rowsServer},
+	{"haproxy_connect_time_average_seconds", "Average connect time over the last 1024 requests.", metricGauge, "AvgConnectTime", 0.001, rowsBackend | rowsServer},
+	{"haproxy_response_time_average_seconds", "Average response time over the last 1024 requests.", metricGauge, "AvgResponseTime", 0.001, rowsBackend | rowsServer},
+	{"haproxy_total_time_average_seconds", "Average total session time over the last 1024 requests.", metricGauge, "AvgTotalTime", 0.001, rowsBackend | rowsServer},
+}
+
+// responseCodeFields are the Statistic fields for haproxy_http_responses_total, by their code label
+var responseCodeFields = []struct{ Code, Field string }{
+	{"1xx", "HTTPResponse1xx"},
+	{"2xx", "HTTPResponse2xx"},
+	{"3xx", "HTTPResponse3xx"},
+	{"4xx", "HTTPResponse4xx"},
+	{"5xx", "HTTPResponse5xx"},
+	{"other", "HTTPResponseOther"},
+}
+
+// statusStates are the values of the haproxy_status enum. HAProxy statuses such as "UP 1/3" or
+// "MAINT (via x/y)" are reduced to their first word.
+var statusStates = []string{"UP", "DOWN", "NOLB", "MAINT", "DRAIN", "OPEN", "FULL", "no check"}
+
+// exporter scrapes every load balancer in the background and serves the results as Prometheus metrics
+type exporter struct {
+	config   *HAProxyCtlConfig
+	mu       sync.Mutex
+	stats    []haproxyctl.FleetStats
+	duration map[string]time.Duration
+}
+
+// runExporter serves Prometheus metrics for every load balancer until it is stopped
+func runExporter(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandExporter)
+	listen := flags.String("listen", ":9101", "Address to serve metrics on")
+	path := flags.String("path", "/metrics", "Path to serve metrics on")
+	interval := flags.Duration("interval", 15*time.Second, "How often to scrape the load balancers")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	e := &exporter{config: c}
+	e.scrape()
+	go func() {
+		for range time.Tick(*interval) {
+			e.scrape()
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(*path, e.serveMetrics)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "<html><head><title>haproxyctl exporter</title></head><body><h1>haproxyctl exporter</h1><p><a href=\"%v\">Metrics</a></p></body></html>\n", *path)
+	})
+
+	log.Printf("Serving metrics for %v load balancers on %v%v", len(c.loadBalancers()), *listen, *path)
+	return http.ListenAndServe(*listen, mux)
+}
+
+// scrape gets the latest statistics from every load balancer, and how long each took
+func (e *exporter) scrape() {
+	start := time.Now()
+	stats := e.config.fleet().GetStats()
+	duration := make(map[string]time.Duration)
+	for _, s := range stats {
+		duration[s.Name] = s.Time.Sub(start)
+	}
+
+	e.mu.Lock()
+	e.stats = stats
+	e.duration = duration
+	e.mu.Unlock()
+}
+
+func (e *exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	stats, duration := e.stats, e.duration
+	e.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(renderMetrics(stats, duration))
+}
+
+// renderMetrics writes the statistics in the Prometheus text exposition format
+func renderMetrics(stats []haproxyctl.FleetStats, duration map[string]time.Duration) []byte {
+	var buf bytes.Buffer
+
+	header := func(name, help string, t metricType) {
+		fmt.Fprintf(&buf, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, t)
+	}
+	sample := func(name string, labels [][2]string, value float64) {
+		var pairs []string
+		for _, l := range labels {
+			pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", l[0], escapeLabel(l[1])))
+		}
+		fmt.Fprintf(&buf, "%v{%v} %v\n", name, strings.Join(pairs, ","), formatText(value))
+	}
+	rows := func(each func(lb string, s *haproxyctl.Statistic)) {
+		for _, fs := range stats {
+			if fs.Err != nil {
+				continue
+			}
+			for i := range *fs.Stats {
+				each(fs.Name, &(*fs.Stats)[i])
+			}
+		}
+	}
+	statLabels := func(lb string, s *haproxyctl.Statistic, extra ...[2]string) [][2]string {
+		return append([][2]string{{"lb", lb}, {"proxy", s.BackendName}, {"server", s.FrontendName}, {"type", s.Type.String()}}, extra...)
+	}
+
+	for _, m := range statMetrics {
+		f, _ := haproxyctl.LookupField(m.Field)
+		header(m.Name, m.Help, m.Type)
+		rows(func(lb string, s *haproxyctl.Statistic) {
+			if !m.Rows.has(s.Type) {
+				return
+			}
+			value := numericValue(f.Value(s))
+			if m.Scale != 0 {
+				value *= m.Scale
+			}
+			sample(m.Name, statLabels(lb, s), value)
+		})
+	}
+
+	header("haproxy_http_responses_total", "Total number of HTTP responses, by status code class.", metricCounter)
+	rows(func(lb string, s *haproxyctl.Statistic) {
+		for _, rc := range responseCodeFields {
+			f, _ := haproxyctl.LookupField(rc.Field)
+			sample("haproxy_http_responses_total", statLabels(lb, s, [2]string{"code", rc.Code}), numericValue(f.Value(s)))
+		}
+	})
+
+	header("haproxy_status", "Current status, as an enum with one series per state.", metricGauge)
+	rows(func(lb string, s *haproxyctl.Statistic) {
+		current := s.Status
+		if !strings.HasPrefix(current, "no check") {
+			current = strings.SplitN(current, " ", 2)[0]
+		}
+		for _, state := range statusStates {
+			value := 0.0
+			if state == current {
+				value = 1
+			}
+			sample("haproxy_status", statLabels(lb, s, [2]string{"state", state}), value)
+		}
+	})
+
+	header("haproxy_check_status", "Status of the last health check. The value is always 1.", metricGauge)
+	rows(func(lb string, s *haproxyctl.Statistic) {
+		if s.CheckStatus == "" {
+			return
+		}
+		sample("haproxy_check_status", statLabels(lb, s, [2]string{"check", s.CheckStatus}), 1)
+	})
+
+	header("haproxyctl_scrape_duration_seconds", "Time taken to scrape each load balancer.", metricGauge)
+	for _, fs := range stats {
+		sample("haproxyctl_scrape_duration_seconds", [][2]string{{"lb", fs.Name}}, duration[fs.Name].Seconds())
+	}
+
+	header("haproxyctl_scrape_error", "Whether the last scrape of each load balancer failed.", metricGauge)
+	for _, fs := range stats {
+		value := 0.0
+		if fs.Err != nil {
+			value = 1
+		}
+		sample("haproxyctl_scrape_error", [][2]string{{"lb", fs.Name}}, value)
+	}
+
+	return buf.Bytes()
+}
+
+// numericValue converts a numeric Statistic field value into a float64. Durations are in seconds.
+func numericValue(v interface{}) float64 {
+	switch x := v.(type) {
+	case uint64:
+		return float64(x)
+	case haproxyctl.Duration:
+		return x.Seconds()
+	}
+	return 0
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
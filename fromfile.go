@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// loadStatsFile reads statistics saved from HAProxy, in its CSV format or the JSON format of "show stat json",
+// or a snapshot from snapshot save. A path of - reads from stdin. Plain statistics are named after the file
+// they came from, and snapshots keep the names of their load balancers.
+func loadStatsFile(path string) ([]haproxyctl.FleetStats, error) {
+	var data []byte
+	var err error
+	name := filepath.Base(path)
+	if path == "-" {
+		name = "stdin"
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		s, err := parseSnapshot(path, data)
+		if err != nil {
+			return nil, err
+		}
+		var stats []haproxyctl.FleetStats
+		for _, lb := range s.LoadBalancers {
+			fs := haproxyctl.FleetStats{Name: lb.Name, Stats: lb.parsed, Raw: []byte(lb.Stats), Time: lb.Time}
+			if lb.parsed == nil {
+				fs.Err = fmt.Errorf("%v", lb.Error)
+			}
+			stats = append(stats, fs)
+		}
+		return stats, nil
+	}
+
+	parsed, err := haproxyctl.DecodeStatistics(data)
+	if err != nil {
+		return nil, err
+	}
+	return []haproxyctl.FleetStats{{Name: name, Stats: parsed, Raw: data, Time: time.Now()}}, nil
+}
+
+// fleetStats gets the statistics of every load balancer, or returns those read from -from-file
+func (c *HAProxyCtlConfig) fleetStats() []haproxyctl.FleetStats {
+	if c.offline != nil {
+		return c.offline
+	}
+	return c.fleet().GetStats()
+}
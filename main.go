@@ -1,187 +1,501 @@
-package main
-
-import (
-	"flag"
-	"fmt"
-	"log"
-	"os"
-	"strings"
-
-	"github.com/BurntSushi/toml"
-	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
-	"github.com/olekukonko/tablewriter"
-)
-
-var (
-	tomlLoc = flag.String("config", "config.toml", "config.toml location")
-)
-
-func main() {
-	flag.Parse()
-
-	var Config HAProxyCtlConfig
-
-	if *tomlLoc != "" {
-		if _, err := toml.DecodeFile(*tomlLoc, &Config); err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	args := flag.Args()
-
-	if len(args) != 1 && len(args) != 3 {
-		printHelp()
-		log.Fatal("Invalid number of arguments, must specify one or three arguments")
-		return
-	}
-
-	var argCommand haproxyctl.Action
-	var argServerName string
-	var argBackendName string
-
-	argCommand = haproxyctl.Action(strings.ToLower(args[0]))
-
-	if len(args) == 3 {
-		argServerName = strings.ToLower(args[1])
-		argBackendName = strings.ToLower(args[2])
-	}
-
-	if argCommand == "" {
-		printHelp()
-		log.Fatal("Cannot specify a blank command")
-		return
-	}
-
-	if !(argCommand == ActionGetDetail ||
-		argCommand == haproxyctl.ActionSetStateToReady ||
-		argCommand == haproxyctl.ActionSetStateToDrain ||
-		argCommand == haproxyctl.ActionSetStateToMaint ||
-		argCommand == haproxyctl.ActionHealthDisableChecks ||
-		argCommand == haproxyctl.ActionHealthEnableChecks ||
-		argCommand == haproxyctl.ActionHealthForceUp ||
-		argCommand == haproxyctl.ActionHealthForceNoLB ||
-		argCommand == haproxyctl.ActionHealthForceDown ||
-		argCommand == haproxyctl.ActionAgentDisablechecks ||
-		argCommand == haproxyctl.ActionAgentEnablechecks ||
-		argCommand == haproxyctl.ActionAgentForceUp ||
-		argCommand == haproxyctl.ActionAgentForceDown ||
-		argCommand == haproxyctl.ActionKillSessions) {
-		printHelp()
-		log.Fatal(fmt.Sprintf("Invalid command specified (%v)", argCommand))
-		return
-	}
-
-	if argServerName == "" && argCommand != ActionGetDetail {
-		printHelp()
-		log.Fatal(fmt.Sprintf("You must specify at least one server name when using the '%v' command", argCommand))
-		return
-	}
-
-	if argBackendName == "" && argCommand != ActionGetDetail {
-		printHelp()
-		log.Fatal(fmt.Sprintf("You must specify a backend when using the '%v' command", argCommand))
-		return
-	}
-
-	argServers := strings.Split(argServerName, ",")
-
-	Config.ProcessInit()
-	//fmt.Println("Load balancers found:", len(*Config.LoadBalancers))
-
-	var outputTable *tablewriter.Table
-	if argCommand == ActionGetDetail {
-		outputTable = Config.getDetails(argServerName)
-	} else {
-		outputTable = Config.sendAction(argCommand, argBackendName, argServers)
-	}
-
-	outputTable.Render()
-
-}
-
-func (c *HAProxyCtlConfig) sendAction(action haproxyctl.Action, backend string, servers []string) *tablewriter.Table {
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"LoadBalancer", "Done", "All OK", "Error"})
-	for _, h := range c.LoadBalancers {
-		done, ok, err := h.HAProxyCtl.SendAction(servers, backend, action)
-		table.Append([]string{
-			h.Name,
-			fmt.Sprintf("%v", done),
-			fmt.Sprintf("%v", ok),
-			fmt.Sprintf("%v", err),
-		})
-	}
-	return table
-}
-
-func (c *HAProxyCtlConfig) getDetails(server string) *tablewriter.Table {
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"LoadBalancer", "Backend", "Server", "Status", "LastCheck", "Downtime", "Error"})
-
-	for _, h := range c.LoadBalancers {
-		stats, err := h.HAProxyCtl.GetStats()
-		if err != nil {
-			table.Append([]string{
-				h.Name,
-				"",
-				"",
-				"ERROR",
-				"",
-				"",
-				fmt.Sprintf("%v", err),
-			})
-			continue
-		}
-
-		for _, s := range *stats {
-			if s.FrontendName == "FRONTEND" || s.FrontendName == "BACKEND" {
-				continue
-			}
-			table.Append([]string{
-				h.Name,
-				s.BackendName,
-				s.FrontendName,
-				s.Status,
-				s.LastCheck,
-				s.Downtime.String(),
-				"",
-			})
-		}
-	}
-
-	return table
-}
-
-func printHelp() {
-	fmt.Println()
-	fmt.Println("HAPROXYCTL HELP")
-	fmt.Println("haproxyctl is a command-line utility to the haproxyctl library.")
-	fmt.Println()
-	fmt.Println("It is used for interacting with haproxy servers via their web admin interface.")
-	fmt.Println()
-	fmt.Println("Usage: haproxyctl [-config config.toml] action server1,server2 backend")
-	fmt.Println("    -config config.toml - Optional parameter to the configuration file for your haproxy nodes")
-	fmt.Println("    action - the action to perform (see below for valid actions)")
-	fmt.Println("    server1,server2 - A comma-seperated list of back-end servers to perform the action on")
-	fmt.Println("    backend - The name of the backend to apply the action to")
-	fmt.Println()
-	fmt.Println("Example: haproxyctl get")
-	fmt.Println("Example: haproxyctl ready ny-web01,ny-web02 prod-web")
-	fmt.Println()
-	fmt.Println("Valid actions are:")
-	fmt.Println("    get      - Gets the status of the backends. No additional arguments are required")
-	fmt.Println("    ready    - Sets the server state to 'ready'")
-	fmt.Println("    drain    - Sets the server state to 'drain")
-	fmt.Println("    maint    - Sets the server state to 'maintenance'")
-	fmt.Println("    dhlth    - Disables health checks")
-	fmt.Println("    ehlth    - Enables health checks")
-	fmt.Println("    hrunn    - Forces the server to be UP")
-	fmt.Println("    hnolb    - Forces the server to disable load balancing")
-	fmt.Println("    hdown    - Forces the server to be DOWN")
-	fmt.Println("    dagent   - Disables agent checks")
-	fmt.Println("    eagent   - Enables agent checks")
-	fmt.Println("    arunn    - Forces agent to be UP")
-	fmt.Println("    adown    - Forces agent to be DOWN")
-	fmt.Println("    shutdown - Kills all sessions")
-	fmt.Println()
-}
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+var (
+	configLoc      = flag.String("config", "", "Configuration file (TOML, YAML or JSON), instead of searching for one")
+	assumeYes      = flag.Bool("yes", false, "Do not ask for confirmation when patterns expand to many servers")
+	confirmOver    = flag.Int("confirm-over", 10, "Ask for confirmation when patterns expand to more than this many servers")
+	columnSpec     = flag.String("columns", "default", "Columns or views to show with get")
+	formatTemplate = flag.String("format", "", "Go template to format each row of output with, instead of -output")
+	where          = flag.String("where", "", "Only show statistics matching this expression with get")
+	sortBy         = flag.String("sort", "", "Fields to sort get by, prefixed with - for descending order")
+	rateInterval   = flag.Duration("interval", 0, "Show the rates of counters over this interval with get, instead of totals")
+	limit          = flag.Int("limit", 0, "Show at most this many statistics with get")
+	outputFormat   = flag.String("output", OutputTable, "Output format: "+strings.Join(outputFormats, ", "))
+	allBackends    = flag.Bool("all-backends", false, "Apply the action to the servers in every backend that contains them")
+	fromFile       = flag.String("from-file", "", "Read statistics from a saved CSV, JSON or snapshot file (or - for stdin) instead of the load balancers")
+	lbNames        = flag.String("lb", "", "Only use the load balancers with these comma-separated names or groups, which can be patterns")
+	selectTags     = flag.String("select", "", "Only use the load balancers with these comma-separated tags, such as dc=ny,env=prod")
+)
+
+func main() {
+	flag.Usage = printHelp
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		printHelp()
+		log.Fatal("No command specified")
+		return
+	}
+
+	cmd := findCommand(commands, args[0])
+	if cmd == nil {
+		//The original form of action servers backend, and get, are kept as aliases of server and stats
+		var err error
+		if args, err = legacyArgs(args); err != nil {
+			printHelp()
+			log.Fatal(err)
+			return
+		}
+		cmd = findCommand(commands, args[0])
+	}
+	leaf := cmd.leaf(args[1:])
+
+	//Usage is shown for -h, or a command without its subcommand, before anything is loaded, so that it works
+	//without a configuration file
+	help := leaf.NoConfig || len(leaf.Commands) > 0
+	for _, arg := range args[1:] {
+		if arg == "-h" || arg == "-help" || arg == "--help" {
+			help = true
+		}
+	}
+
+	var Config HAProxyCtlConfig
+
+	//Statistics from a file do not need any load balancers, so the configuration is optional unless it is given
+	if !help && (*fromFile == "" || *configLoc != "") {
+		loaded, err := loadConfig(*configLoc)
+		if _, none := err.(noConfigError); none && leaf.OptionalConfig {
+			loaded, err = &HAProxyCtlConfig{}, nil
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		Config = *loaded
+		Config.discover()
+	}
+
+	if *fromFile != "" {
+		if !leaf.Offline {
+			printHelp()
+			log.Fatal("-from-file can only be used with stats get, summary and top")
+			return
+		}
+		var err error
+		if Config.offline, err = loadStatsFile(*fromFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := Config.applySelector(*lbNames, *selectTags); err != nil {
+		log.Fatal(err)
+	}
+
+	if !validOutputFormat(*outputFormat) {
+		printHelp()
+		log.Fatal(fmt.Sprintf("Invalid output format specified (%v)", *outputFormat))
+		return
+	}
+
+	if *formatTemplate != "" {
+		if _, err := parseFormat(*formatTemplate); err != nil {
+			printHelp()
+			log.Fatal(err)
+			return
+		}
+	}
+
+	if !leaf.NoInit && !help {
+		if err := Config.ProcessInit(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := cmd.run(&Config, args[1:]); err != nil && err != flag.ErrHelp {
+		log.Fatal(err)
+	}
+}
+
+// runServerAction returns the server command for an action, which sends it to servers in a backend, or in every
+// backend that contains them
+func runServerAction(action haproxyctl.Action) func(c *HAProxyCtlConfig, args []string) error {
+	return func(c *HAProxyCtlConfig, args []string) error {
+		flags := newFlagSet(CommandServer + " " + string(action))
+		backend := flags.String("backend", "", "Backend the servers are in, which can be a pattern")
+		all := flags.Bool("all-backends", *allBackends, "Apply the action to the servers in every backend that contains them")
+		flags.BoolVar(assumeYes, "yes", *assumeYes, "Do not ask for confirmation when patterns expand to many servers")
+		flags.IntVar(confirmOver, "confirm-over", *confirmOver, "Ask for confirmation when patterns expand to more than this many servers")
+		addOutputFlags(flags)
+		if err := parseFlags(flags, args); err != nil {
+			return err
+		}
+
+		var servers []string
+		for _, arg := range flags.Args() {
+			servers = append(servers, haproxyctl.SplitPatterns(arg)...)
+		}
+		switch {
+		case len(servers) == 0:
+			return fmt.Errorf("You must specify at least one server name when using the '%v' command", action)
+		case *backend == "" && !*all:
+			return fmt.Errorf("You must specify a backend with -backend, or -all-backends, when using the '%v' command", action)
+		case *backend != "" && *all:
+			return fmt.Errorf("-backend and -all-backends cannot be used together")
+		}
+		if *all {
+			*backend = haproxyctl.AllBackends
+		}
+
+		backendPattern, err := haproxyctl.ParsePattern(*backend)
+		if err != nil {
+			return err
+		}
+		serverPatterns, err := haproxyctl.ParsePatterns(servers)
+		if err != nil {
+			return err
+		}
+		output, err := c.sendAction(action, backendPattern, serverPatterns)
+		if err != nil {
+			return err
+		}
+		return writeReport(output)
+	}
+}
+
+// runStatsGet shows the statistics of every load balancer. Its flags default to the global flags of the same
+// name, which the original get form uses.
+func runStatsGet(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandStats + " " + ActionGetDetail)
+	columnList := flags.String("columns", *columnSpec, "Comma-separated statistic fields or views to show")
+	whereExpr := flags.String("where", *where, "Only show statistics matching this expression")
+	sortFields := flags.String("sort", *sortBy, "Comma-separated fields to sort by, prefixed with - for descending order")
+	maxRows := flags.Int("limit", *limit, "Show at most this many statistics")
+	interval := flags.Duration("interval", *rateInterval, "Show the rates of counters over this interval, instead of totals")
+	addOutputFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("%v %v takes no arguments", CommandStats, ActionGetDetail)
+	}
+	if c.offline != nil && *interval > 0 {
+		return fmt.Errorf("-interval cannot be used with -from-file, which only has one set of statistics")
+	}
+
+	columns, err := parseColumns(*columnList, *interval > 0)
+	if err != nil {
+		return err
+	}
+	query := &statQuery{Limit: *maxRows}
+	if *whereExpr != "" {
+		if query.Where, err = haproxyctl.ParseFilter(*whereExpr); err != nil {
+			return err
+		}
+	}
+	if query.Sort, err = haproxyctl.ParseSortKeys(*sortFields); err != nil {
+		return err
+	}
+	return writeReport(c.getDetails(columns, query, *interval))
+}
+
+// outputUsage is the usage of the flags added by addOutputFlags
+const outputUsage = "[-output format] [-format template]"
+
+// addOutputFlags adds -output and -format to the flags of a command that writes a report. They default to the
+// global flags, so they can be given before or after the command.
+func addOutputFlags(flags *flag.FlagSet) {
+	flags.StringVar(outputFormat, "output", *outputFormat, "Output format: "+strings.Join(outputFormats, ", "))
+	flags.StringVar(formatTemplate, "format", *formatTemplate, "Go template to format each row of output with, instead of -output")
+}
+
+// writeReport writes a command's output to stdout, using the -format template or -output format. If any of its
+// rows are errors, they are returned once the whole report is written, so that the command exits non-zero.
+func writeReport(output *report) error {
+	var err error
+	if *formatTemplate != "" {
+		var format *template.Template
+		if format, err = parseFormat(*formatTemplate); err == nil {
+			err = output.writeTemplate(os.Stdout, format)
+		}
+	} else {
+		err = output.Write(os.Stdout, *outputFormat)
+	}
+	if err == nil && len(output.Failures) > 0 {
+		err = fmt.Errorf("%v", strings.Join(output.Failures, "; "))
+	}
+	return err
+}
+
+// actionRow is the result of sending an action to one backend of a load balancer
+type actionRow struct {
+	LB string
+	haproxyctl.ActionResult
+}
+
+var actionColumns = []column{
+	{Key: "lb", Header: "LoadBalancer", Value: func(r interface{}) interface{} { return r.(*actionRow).LB }},
+	{Key: "backend", Header: "Backend", Value: func(r interface{}) interface{} { return r.(*actionRow).Backend }},
+	{Key: "servers", Header: "Servers", Value: func(r interface{}) interface{} { return r.(*actionRow).Servers }},
+	{Key: "done", Header: "Done", Value: func(r interface{}) interface{} { return r.(*actionRow).Done }},
+	{Key: "allok", Header: "All OK", Value: func(r interface{}) interface{} { return r.(*actionRow).AllOK }},
+	{Key: "error", Header: "Error", Value: func(r interface{}) interface{} { return errorValue(r.(*actionRow).Err) }},
+}
+
+// sendAction expands the patterns on every load balancer and sends the action to the servers they match, unless
+// the user does not confirm it
+func (c *HAProxyCtlConfig) sendAction(action haproxyctl.Action, backend *haproxyctl.Pattern, servers []*haproxyctl.Pattern) (*report, error) {
+	plans := c.resolveTargets(backend, servers)
+	if !confirmPlans(action, plans, isLiteral(backend, servers)) {
+		return nil, fmt.Errorf("aborted, no action was sent")
+	}
+	return executePlans(action, plans), nil
+}
+
+// executePlans sends the action to the targets of each plan, and reports the result for each backend
+func executePlans(action haproxyctl.Action, plans []targetPlan) *report {
+	output := &report{Columns: actionColumns}
+	for _, p := range plans {
+		if p.Err != nil {
+			output.Rows = append(output.Rows, &actionRow{
+				LB:           p.LoadBalancer.Name,
+				ActionResult: haproxyctl.ActionResult{Err: p.Err},
+			})
+			output.fail(p.LoadBalancer.Name, p.Err)
+			continue
+		}
+		for _, r := range p.LoadBalancer.HAProxyCtl.SendActionToTargets(p.Targets, action) {
+			output.Rows = append(output.Rows, &actionRow{LB: p.LoadBalancer.Name, ActionResult: r})
+			if r.Err != nil {
+				output.fail(p.LoadBalancer.Name, r.Err)
+			}
+		}
+	}
+	return output
+}
+
+// targetPlan is the set of targets that patterns expanded to on a single load balancer
+type targetPlan struct {
+	LoadBalancer *LoadBalancer
+	Targets      []haproxyctl.Target
+	Err          error
+}
+
+// resolveTargets expands the backend and server patterns against the live statistics of every load balancer
+func (c *HAProxyCtlConfig) resolveTargets(backend *haproxyctl.Pattern, servers []*haproxyctl.Pattern) []targetPlan {
+	var plans []targetPlan
+	lbs := c.loadBalancers()
+	for i := range lbs {
+		h := &lbs[i]
+		stats, err := h.HAProxyCtl.GetStats()
+		if err != nil {
+			plans = append(plans, targetPlan{LoadBalancer: h, Err: err})
+			continue
+		}
+		targets, err := stats.ResolveTargets(backend, servers)
+		plans = append(plans, targetPlan{LoadBalancer: h, Targets: targets, Err: err})
+	}
+	return plans
+}
+
+func isLiteral(backend *haproxyctl.Pattern, servers []*haproxyctl.Pattern) bool {
+	if !backend.IsLiteral() {
+		return false
+	}
+	for _, s := range servers {
+		if !s.IsLiteral() {
+			return false
+		}
+	}
+	return true
+}
+
+// confirmPlans prints the expanded target list, and asks the user to confirm if it is larger than expected
+func confirmPlans(action haproxyctl.Action, plans []targetPlan, literal bool) bool {
+	count := 0
+	for _, p := range plans {
+		for _, t := range p.Targets {
+			fmt.Fprintf(os.Stderr, "%v: %v: %v\n", p.LoadBalancer.Name, t.Backend, strings.Join(t.Servers, ", "))
+			count += len(t.Servers)
+		}
+	}
+
+	if literal || *assumeYes || count <= *confirmOver {
+		return true
+	}
+
+	fmt.Fprintf(os.Stderr, "Apply '%v' to %v servers? [y/N] ", action, count)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// statRow is a line of statistics from a load balancer, or the error from retrieving them
+type statRow struct {
+	LB    string
+	Err   error
+	Rates map[string]float64 // Per-second rates of the counters, when get is given an interval
+	haproxyctl.Statistic
+}
+
+// statQuery selects, orders and limits the statistics shown by get
+type statQuery struct {
+	Where   *haproxyctl.Filter
+	Sort    haproxyctl.SortKeys
+	Limit   int
+	Backend func(name string) bool // Optional, only the statistics of the backends it returns true for are shown
+}
+
+// include returns true if the statistic should be shown. Only servers are shown, unless the filter refers to
+// the type field, so that frontends and backends can be asked for.
+func (q *statQuery) include(s *haproxyctl.Statistic) bool {
+	if q.Backend != nil && !q.Backend(s.BackendName) {
+		return false
+	}
+	if q.Where == nil {
+		return s.Type == haproxyctl.Server
+	}
+	for _, f := range q.Where.Fields() {
+		if f.Name == "Type" {
+			return q.Where.Match(s)
+		}
+	}
+	return s.Type == haproxyctl.Server && q.Where.Match(s)
+}
+
+// getDetails gets the statistics from every load balancer. If interval is set, the statistics are fetched twice,
+// interval apart, and the rates of the counters between the two are given in place of their totals.
+func (c *HAProxyCtlConfig) getDetails(columns []column, query *statQuery, interval time.Duration) *report {
+	output := &report{Columns: columns}
+	var rows []*statRow
+
+	latest := c.fleetStats()
+	var previous []haproxyctl.FleetStats
+	if interval > 0 {
+		previous = latest
+		time.Sleep(interval)
+		latest = c.fleet().GetStats()
+	}
+
+	for i, l := range latest {
+		if l.Err == nil && previous != nil && previous[i].Err != nil {
+			l.Err = previous[i].Err
+		}
+		if l.Err != nil {
+			output.Rows = append(output.Rows, &statRow{LB: l.Name, Err: l.Err})
+			output.fail(l.Name, l.Err)
+			continue
+		}
+
+		var rates map[string]map[string]float64
+		if previous != nil {
+			rates = make(map[string]map[string]float64)
+			for _, r := range haproxyctl.ComputeRates(previous[i].Stats, l.Stats, l.Time.Sub(previous[i].Time)) {
+				rates[r.BackendName+"/"+r.FrontendName] = r.PerSecond
+			}
+		}
+
+		for _, s := range *l.Stats {
+			if !query.include(&s) {
+				continue
+			}
+			row := &statRow{LB: l.Name, Statistic: s}
+			if rates != nil {
+				row.Rates = rates[haproxyctl.StatisticKey(&s)]
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	//Load balancer errors are listed first, and are not subject to sorting or limits
+	if query.Sort != nil {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return query.Sort.Less(&rows[i].Statistic, &rows[j].Statistic)
+		})
+	}
+	if query.Limit > 0 && len(rows) > query.Limit {
+		rows = rows[:query.Limit]
+	}
+	for _, r := range rows {
+		output.Rows = append(output.Rows, r)
+	}
+
+	return output
+}
+
+func printHelp() {
+	fmt.Println()
+	fmt.Println("HAPROXYCTL HELP")
+	fmt.Println("haproxyctl is a command-line utility to the haproxyctl library.")
+	fmt.Println()
+	fmt.Println("It is used for interacting with haproxy servers via their web admin interface.")
+	fmt.Println()
+	fmt.Println("Usage: haproxyctl [global flags] command [flags] [arguments]")
+	fmt.Println("       haproxyctl [global flags] action server1,server2 backend")
+	fmt.Println("       haproxyctl [global flags] -all-backends action server1,server2")
+	fmt.Println("       haproxyctl [global flags] get")
+	fmt.Println()
+	fmt.Println("The last three are the original forms, and are the same as server action -backend backend server1,server2,")
+	fmt.Println("server action -all-backends server1,server2 and stats get.")
+	fmt.Println()
+	fmt.Println("Commands are:")
+	printCommandList(os.Stdout, commands)
+	fmt.Println()
+	fmt.Println("Run haproxyctl help command, or haproxyctl command -h, for the flags and arguments of a command.")
+	fmt.Println()
+	fmt.Println("Global flags are:")
+	fmt.Println("    -config config.toml - Optional configuration file for your haproxy nodes, in TOML, YAML or JSON. Without it,")
+	fmt.Println("                          $HAPROXYCTL_CONFIG, ./config.toml, ~/.config/haproxyctl/config.* and")
+	fmt.Println("                          /etc/haproxyctl/config.* are merged, with the first taking precedence")
+	fmt.Println("    -output format - Output format: table (default), json, jsonl, csv, yaml or tsv")
+	fmt.Println("    -format template - Go template to format each row of output with, instead of -output (see below)")
+	fmt.Println("                       -output and -format can also be given after the commands that print reports, as in")
+	fmt.Println("                       summary -output json")
+	fmt.Println("    -columns, -where, -sort, -limit, -interval - Flags of get, and defaults for the same flags of stats get")
+	fmt.Println("    -yes - Do not ask for confirmation when patterns expand to many servers")
+	fmt.Println("    -confirm-over n - Ask for confirmation when patterns expand to more than n servers (default 10)")
+	fmt.Println("    -all-backends - Apply the action to the servers in every backend that contains them, with an action")
+	fmt.Println("    -from-file file - Read statistics for stats get, summary or top from a saved HAProxy CSV, show stat json")
+	fmt.Println("                      output or snapshot file, or - for stdin, instead of the load balancers")
+	fmt.Println("    -lb names - Only use the load balancers with these comma-separated names or groups, which can be patterns")
+	fmt.Println("    -select tags - Only use the load balancers with all of these comma-separated tags, as in dc=ny,env!=dev")
+	fmt.Println("    action - the action to perform, as with server (see below for valid actions)")
+	fmt.Println("    server1,server2 - A comma-seperated list of back-end servers to perform the action on")
+	fmt.Println("    backend - The name of the backend to apply the action to")
+	fmt.Println()
+	fmt.Println(patternHelp)
+	fmt.Println()
+	fmt.Println("Example: haproxyctl stats get")
+	fmt.Println("Example: haproxyctl stats get -columns traffic,weight")
+	fmt.Println("Example: haproxyctl stats get -interval 10s -columns errors")
+	fmt.Println("Example: haproxyctl stats get -where 'status != \"UP\" && scur > 50' -sort -scur -limit 10")
+	fmt.Println("Example: haproxyctl server drain -backend prod-web ny-web01 ny-web02")
+	fmt.Println("Example: haproxyctl server maint -backend '/^prod-(web|api)$/' 'ny-web*'")
+	fmt.Println("Example: haproxyctl server maint -all-backends ny-web01")
+	fmt.Println("Example: haproxyctl ready ny-web01,ny-web02 prod-web")
+	fmt.Println("Example: haproxyctl -select dc=ny,env=prod server drain -backend prod-web ny-web01")
+	fmt.Println("Example: haproxyctl -lb edge summary")
+	fmt.Println("Example: haproxyctl -output json stats get | jq '.[] | select(.status != \"UP\")'")
+	fmt.Println("Example: haproxyctl -from-file ticket-1234-stats.csv stats get -where 'status != \"UP\"'")
+	fmt.Println("Example: haproxyctl -format '{{pad 6 .LB}} {{.BackendName}}/{{.FrontendName}} {{.Status}}' stats get")
+	fmt.Println()
+	fmt.Println("Columns can be any statistic field, by Go name (SessionsCurrent) or HAProxy name (scur), or one of")
+	fmt.Println("the views: default, health, traffic, errors, latency and capacity.")
+	fmt.Println()
+	fmt.Println("Expressions for -where compare statistic fields with values using ==, !=, <, <=, >, >=, =~ and !~,")
+	fmt.Println("combined with &&, || and !. Strings are double-quoted and durations compare in seconds (or 30s, 5m),")
+	fmt.Println("except with the timings HAProxy reports in milliseconds, such as rtime, where rtime > 1s is rtime > 1000.")
+	fmt.Println("Only servers are shown unless the expression refers to type, as in: type == \"backend\" && hrsp_5xx > 0")
+	fmt.Println()
+	fmt.Println("Templates for -format use Go's text/template syntax. Rows from stats get have the LB, Err and Statistic")
+	fmt.Println("fields (BackendName, FrontendName, Status, SessionsCurrent, ...), and action results have LB, Backend,")
+	fmt.Println("Servers, Done, AllOK and Err. The functions bytes, duration, ms, pad, lpad, join, upper, lower and")
+	fmt.Println("json are available, as in: {{.BytesIn | bytes}} {{.Downtime | duration}} {{join .Servers \",\"}}")
+	fmt.Println()
+	fmt.Println("Valid actions are:")
+	printCommandList(os.Stdout, commandPaths[CommandServer].Commands)
+	fmt.Println()
+}
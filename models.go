@@ -1,44 +1,144 @@
-package main
-
-import (
-	"net/url"
-
-	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
-)
-
-type HAProxyCtlConfig struct {
-	DefaultUsername string
-	DefaultPassword string
-	LoadBalancers   []LoadBalancer
-}
-
-type LoadBalancer struct {
-	Name       string
-	Url        string
-	Username   string
-	Password   string
-	HAProxyCtl haproxyctl.HAProxyConfig
-}
-
-func (c *HAProxyCtlConfig) ProcessInit() {
-	for i, x := range c.LoadBalancers {
-		thisUsername := x.Username
-		if thisUsername == "" {
-			thisUsername = c.DefaultUsername
-		}
-		thisPassword := x.Password
-		if thisPassword == "" {
-			thisPassword = c.DefaultPassword
-		}
-		thisURL, _ := url.Parse(x.Url)
-		c.LoadBalancers[i].HAProxyCtl = haproxyctl.HAProxyConfig{
-			Username: thisUsername,
-			Password: thisPassword,
-			URL:      *thisURL,
-		}
-	}
-}
-
-const (
-	ActionGetDetail = "get"
-)
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// HAProxyCtlConfig is the configuration file, which can be TOML, YAML or JSON. The keys are the same in all of
+// them, so the YAML tags give the field names as they are.
+type HAProxyCtlConfig struct {
+	DefaultUsername    string              `yaml:"DefaultUsername"`
+	DefaultPassword    string              `yaml:"DefaultPassword"`
+	LoadBalancers      []LoadBalancer      `yaml:"LoadBalancers"`
+	LoadBalancerGroups []LoadBalancerGroup `yaml:"LoadBalancerGroups"` // Load balancers found from DNS SRV records or inventories
+	CredentialsFile    string              `yaml:"CredentialsFile"`    // TOML file of credentials by load balancer name, which must be chmod 600
+	CredentialHelper   string              `yaml:"CredentialHelper"`   // git-credential style command to get credentials from
+	KeyFile            string              `yaml:"KeyFile"`            // Key file that passwords from secret encrypt were encrypted with
+	APITokens          []APIToken          `yaml:"APITokens"`
+	Webhooks           []Webhook           `yaml:"Webhooks"`
+
+	files     []string                // Configuration files that were read, highest precedence first
+	problems  configProblems          // Problems found while reading them, such as unknown keys
+	discovery *discovery              // The load balancers found in LoadBalancerGroups
+	offline   []haproxyctl.FleetStats // Statistics read from -from-file, used instead of the load balancers
+}
+
+type LoadBalancer struct {
+	Name       string                   `yaml:"Name"`
+	Url        string                   `yaml:"Url"`
+	Username   string                   `yaml:"Username"`
+	Password   string                   `yaml:"Password"`
+	Groups     []string                 `yaml:"Groups"`  // Groups the load balancer is in, which -lb can select it by
+	Tags       map[string]string        `yaml:"Tags"`    // Tags such as env = "prod", which -select can select it by
+	Timeout    configDuration           `yaml:"Timeout"` // How long a request to the load balancer can take (default 10s)
+	HAProxyCtl haproxyctl.HAProxyConfig `toml:"-" json:"-" yaml:"-"`
+
+	credential credential // The credentials in use, and where they came from
+}
+
+// ProcessInit checks the configuration and works out the URL and credentials of every load balancer. See
+// credentialSources.resolve for where credentials are looked for. Every error found is returned together, as
+// configProblems.
+func (c *HAProxyCtlConfig) ProcessInit() error {
+	if errs := c.processInit().errors(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// processInit does the work of ProcessInit, returning warnings as well as errors
+func (c *HAProxyCtlConfig) processInit() configProblems {
+	problems := append(configProblems{}, c.problems...)
+	problems = append(problems, c.checkNames()...)
+	problems = append(problems, c.checkWebhooks()...)
+
+	sources, err := newCredentialSources(c)
+	if err != nil {
+		return append(problems, configProblem{Severity: severityError, Check: checkCredentials, Field: "CredentialsFile", Message: err.Error()})
+	}
+	keys := newSecretKeys(c)
+	for i := range c.LoadBalancers {
+		problems = append(problems, c.LoadBalancers[i].init(sources, keys)...)
+	}
+	return problems
+}
+
+// init works out the URL and credentials of a load balancer
+func (lb *LoadBalancer) init(sources *credentialSources, keys *secretKeys) configProblems {
+	thisURL, err := parseStatsURL(lb.Url)
+	if err != nil {
+		return configProblems{lb.problem(checkURL, "Url", err)}
+	}
+	cred, err := sources.resolve(lb, thisURL)
+	if err != nil {
+		return configProblems{lb.problem(checkCredentials, "", err)}
+	}
+	if isEncrypted(cred.Password) {
+		if cred.Password, err = decryptSecret(cred.Password, keys); err != nil {
+			return configProblems{lb.problem(checkCredentials, "Password", fmt.Errorf("password from %v: %v", cred.passwordSource, err))}
+		}
+	}
+	lb.credential = cred
+	lb.HAProxyCtl = haproxyctl.HAProxyConfig{
+		Username: cred.Username,
+		Password: cred.Password,
+		URL:      *thisURL,
+		Timeout:  lb.Timeout.Duration,
+	}
+	//Create the HTTP client now, so that copies of the load balancer share it rather than racing to create their own
+	lb.HAProxyCtl.GetRequestURI(true)
+	return lb.checkCredential()
+}
+
+// fleet returns the configured load balancers as a haproxyctl.Fleet, including those currently found in
+// LoadBalancerGroups
+func (c *HAProxyCtlConfig) fleet() haproxyctl.Fleet {
+	return newFleet(c.loadBalancers())
+}
+
+// newFleet returns the load balancers as a haproxyctl.Fleet, whose members refer to them
+func newFleet(lbs []LoadBalancer) haproxyctl.Fleet {
+	var f haproxyctl.Fleet
+	for i := range lbs {
+		f = append(f, haproxyctl.FleetMember{
+			Name:   lbs[i].Name,
+			Config: &lbs[i].HAProxyCtl,
+		})
+	}
+	return f
+}
+
+// configDuration is a duration in the configuration file, written as a string such as "30s" or "5m"
+type configDuration struct {
+	time.Duration
+}
+
+func (d *configDuration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (d configDuration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+const (
+	ActionGetDetail   = "get"
+	CommandTop        = "top"
+	CommandSummary    = "summary"
+	CommandExporter   = "exporter"
+	CommandServe      = "serve"
+	CommandEvents     = "events"
+	CommandNotify     = "notify"
+	CommandSnapshot   = "snapshot"
+	CommandSecret     = "secret"
+	CommandConfig     = "config"
+	CommandServer     = "server"
+	CommandStats      = "stats"
+	CommandHelp       = "help"
+	CommandCompletion = "completion"
+)
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// Webhook is an HTTP endpoint that is sent a POST for state-change events by the notify command
+type Webhook struct {
+	Name       string         `yaml:"Name"`
+	Url        string         `yaml:"Url"`
+	Events     []string       `yaml:"Events"`     // Event types to send. Defaults to server_down, backend_down and lb_unreachable.
+	Template   string         `yaml:"Template"`   // Go template for the JSON body, executed with the event. Defaults to the event itself.
+	Retries    *int           `yaml:"Retries"`    // Number of times to retry a failed POST (default 3, and 0 for none)
+	RetryDelay configDuration `yaml:"RetryDelay"` // Delay before the first retry, which doubles with each retry (default 2s)
+	Hold       configDuration `yaml:"Hold"`       // How long failures are held back, and dropped if they recover in the meantime
+	Quiet      configDuration `yaml:"Quiet"`      // How long the same event is not sent again for (default 5m)
+}
+
+// defaultWebhookEvents are the events sent to webhooks that do not list any
+var defaultWebhookEvents = []string{
+	string(haproxyctl.EventServerDown),
+	string(haproxyctl.EventBackendDown),
+	string(haproxyctl.EventUnreachable),
+}
+
+// recoveryOf maps each recovery event to the events it ends
+var recoveryOf = map[haproxyctl.EventType][]haproxyctl.EventType{
+	haproxyctl.EventServerUp: {
+		haproxyctl.EventServerDown,
+		haproxyctl.EventServerMaint,
+		haproxyctl.EventServerDrain,
+		haproxyctl.EventServerNoLB,
+	},
+	haproxyctl.EventBackendUp: {haproxyctl.EventBackendDown},
+	haproxyctl.EventReachable: {haproxyctl.EventUnreachable},
+}
+
+// notifier sends the events a single webhook is interested in, holding back failures that recover quickly and
+// leaving out repeats
+type notifier struct {
+	hook     Webhook
+	events   map[haproxyctl.EventType]bool
+	body     *template.Template
+	client   *http.Client
+	mu       sync.Mutex
+	pending  map[string]*time.Timer // Failures being held back, by eventKey
+	lastSent map[string]time.Time   // When each event was last sent, by eventKey
+}
+
+// eventKey identifies the subject and type of an event, so that repeats and recoveries can be matched up
+func eventKey(t haproxyctl.EventType, e haproxyctl.Event) string {
+	return strings.Join([]string{string(t), e.LB, e.Backend, e.Server}, "/")
+}
+
+// eventSubject names the load balancer, backend or server an event is about, for logging
+func eventSubject(e haproxyctl.Event) string {
+	return strings.Trim(strings.Join([]string{e.LB, e.Backend, e.Server}, "/"), "/")
+}
+
+// newNotifier checks a webhook's configuration and fills in its defaults
+func newNotifier(hook Webhook) (*notifier, error) {
+	if hook.Url == "" {
+		return nil, fmt.Errorf("webhook %v has no Url set", hook.Name)
+	}
+	if len(hook.Events) == 0 {
+		hook.Events = defaultWebhookEvents
+	}
+	if hook.Template == "" {
+		hook.Template = "{{json .}}"
+	}
+	if hook.Retries == nil {
+		retries := 3
+		hook.Retries = &retries
+	}
+	if hook.RetryDelay.Duration == 0 {
+		hook.RetryDelay.Duration = 2 * time.Second
+	}
+	if hook.Quiet.Duration == 0 {
+		hook.Quiet.Duration = 5 * time.Minute
+	}
+
+	n := &notifier{
+		hook:     hook,
+		events:   make(map[haproxyctl.EventType]bool),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		pending:  make(map[string]*time.Timer),
+		lastSent: make(map[string]time.Time),
+	}
+	for _, e := range hook.Events {
+		n.events[haproxyctl.EventType(strings.ToLower(e))] = true
+	}
+
+	var err error
+	if n.body, err = template.New(hook.Name).Funcs(templateFuncs).Parse(hook.Template); err != nil {
+		return nil, fmt.Errorf("webhook %v: %v", hook.Name, err)
+	}
+	return n, nil
+}
+
+// handle decides whether to send an event now, hold it back, or drop it
+func (n *notifier) handle(e haproxyctl.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, failure := range recoveryOf[e.Type] {
+		key := eventKey(failure, e)
+		if timer, ok := n.pending[key]; ok {
+			//The failure recovered before it was sent, so neither is worth sending
+			timer.Stop()
+			delete(n.pending, key)
+			log.Printf("%v: %v for %v recovered within %v, not sending", n.hook.Name, failure, eventSubject(e), n.hook.Hold.Duration)
+			return
+		}
+	}
+
+	if !n.events[e.Type] {
+		return
+	}
+
+	key := eventKey(e.Type, e)
+	if _, ok := recoveryOf[e.Type]; !ok && n.hook.Hold.Duration > 0 {
+		if _, ok := n.pending[key]; ok {
+			return
+		}
+		n.pending[key] = time.AfterFunc(n.hook.Hold.Duration, func() {
+			n.mu.Lock()
+			_, ok := n.pending[key]
+			delete(n.pending, key)
+			n.mu.Unlock()
+			if ok {
+				n.send(key, e)
+			}
+		})
+		return
+	}
+
+	go n.send(key, e)
+}
+
+// send posts the event to the webhook, unless the same event was sent recently, retrying if it fails
+func (n *notifier) send(key string, e haproxyctl.Event) {
+	n.mu.Lock()
+	if last, ok := n.lastSent[key]; ok && time.Since(last) < n.hook.Quiet.Duration {
+		n.mu.Unlock()
+		return
+	}
+	n.lastSent[key] = time.Now()
+	n.mu.Unlock()
+
+	var body bytes.Buffer
+	if err := n.body.Execute(&body, e); err != nil {
+		log.Printf("%v: %v", n.hook.Name, err)
+		return
+	}
+	if !json.Valid(body.Bytes()) {
+		log.Printf("%v: template did not produce valid JSON: %v", n.hook.Name, body.String())
+		return
+	}
+
+	delay := n.hook.RetryDelay.Duration
+	for attempt := 0; ; attempt++ {
+		err := n.post(body.Bytes())
+		if err == nil {
+			log.Printf("%v: sent %v for %v", n.hook.Name, e.Type, eventSubject(e))
+			return
+		}
+		if attempt >= *n.hook.Retries {
+			log.Printf("%v: giving up on %v after %v attempts: %v", n.hook.Name, e.Type, attempt+1, err)
+			return
+		}
+		log.Printf("%v: %v, retrying in %v", n.hook.Name, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (n *notifier) post(body []byte) error {
+	response, err := n.client.Post(n.hook.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		//Leave out the URL, whose path or query can be the webhook's secret
+		if uerr, ok := err.(*url.Error); ok {
+			return fmt.Errorf("cannot post: %v", uerr.Err)
+		}
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("status code %v", response.StatusCode)
+	}
+	return nil
+}
+
+// runNotify watches the load balancers and sends their state-change events to the configured webhooks, until
+// it is stopped
+func runNotify(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandNotify)
+	interval := flags.Duration("interval", 5*time.Second, "How often to poll the load balancers")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if len(c.Webhooks) == 0 {
+		return fmt.Errorf("notify needs at least one [[Webhooks]] entry in the configuration file")
+	}
+	var notifiers []*notifier
+	for _, hook := range c.Webhooks {
+		n, err := newNotifier(hook)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("Sending events for %v load balancers to %v webhooks", len(c.loadBalancers()), len(notifiers))
+	for e := range haproxyctl.WatchFleet(ctx, *interval, c.fleet) {
+		for _, n := range notifiers {
+			n.handle(e)
+		}
+	}
+	return nil
+}
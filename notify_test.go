@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// webhookReceiver is a local webhook that records the bodies it is sent, failing the first few of them
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failures int
+	bodies   []string
+	times    []time.Time
+	received chan struct{}
+}
+
+func newWebhookReceiver(failures int) *webhookReceiver {
+	r := &webhookReceiver{failures: failures, received: make(chan struct{}, 100)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		r.bodies = append(r.bodies, string(body))
+		r.times = append(r.times, time.Now())
+		fail := len(r.bodies) <= r.failures
+		r.mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		r.received <- struct{}{}
+	}))
+	return r
+}
+
+// wait waits for n more POSTs, failing the test if they do not arrive
+func (r *webhookReceiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %v POSTs, want %v", len(r.posts()), n)
+		}
+	}
+}
+
+// quiet checks that nothing more is posted for a while
+func (r *webhookReceiver) quiet(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case <-r.received:
+		t.Fatalf("got an unexpected POST: %v", r.posts())
+	case <-time.After(d):
+	}
+}
+
+func (r *webhookReceiver) posts() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...)
+}
+
+func testNotifier(t *testing.T, hook Webhook) *notifier {
+	t.Helper()
+	if hook.Name == "" {
+		hook.Name = "test"
+	}
+	if hook.RetryDelay.Duration == 0 {
+		hook.RetryDelay.Duration = 10 * time.Millisecond
+	}
+	n, err := newNotifier(hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func serverEvent(t haproxyctl.EventType, server string) haproxyctl.Event {
+	return haproxyctl.Event{LB: "LB01", Type: t, Backend: "prod-web", Server: server}
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	r := newWebhookReceiver(0)
+	defer r.Close()
+	n := testNotifier(t, Webhook{Url: r.URL, Template: `{"text": {{printf "%v: %v/%v is %v" .LB .Backend .Server .Type | json}}}`})
+
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web01"))
+	r.wait(t, 1)
+	var body struct{ Text string }
+	if err := json.Unmarshal([]byte(r.posts()[0]), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Text != "LB01: prod-web/web01 is server_down" {
+		t.Errorf("got %q", body.Text)
+	}
+
+	//Without a template, the event itself is sent
+	r2 := newWebhookReceiver(0)
+	defer r2.Close()
+	n = testNotifier(t, Webhook{Url: r2.URL})
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web01"))
+	r2.wait(t, 1)
+	var e haproxyctl.Event
+	if err := json.Unmarshal([]byte(r2.posts()[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != haproxyctl.EventServerDown || e.Server != "web01" {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestNotifyEvents(t *testing.T) {
+	r := newWebhookReceiver(0)
+	defer r.Close()
+	n := testNotifier(t, Webhook{Url: r.URL, Events: []string{"server_maint"}})
+
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web01"))
+	n.handle(serverEvent(haproxyctl.EventServerMaint, "web01"))
+	r.wait(t, 1)
+	r.quiet(t, 50*time.Millisecond)
+	if !strings.Contains(r.posts()[0], "server_maint") {
+		t.Errorf("got %v, want only server_maint", r.posts())
+	}
+}
+
+func TestNotifyRetry(t *testing.T) {
+	r := newWebhookReceiver(2)
+	defer r.Close()
+	n := testNotifier(t, Webhook{Url: r.URL})
+
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web01"))
+	r.wait(t, 3)
+	r.quiet(t, 100*time.Millisecond)
+
+	//The delay before each retry doubles, from RetryDelay
+	r.mu.Lock()
+	first, second := r.times[1].Sub(r.times[0]), r.times[2].Sub(r.times[1])
+	r.mu.Unlock()
+	if first < 10*time.Millisecond || second < 20*time.Millisecond {
+		t.Errorf("retried after %v and %v, want at least 10ms and 20ms", first, second)
+	}
+}
+
+func TestNotifyGivesUp(t *testing.T) {
+	r := newWebhookReceiver(100)
+	defer r.Close()
+	retries := 1
+	n := testNotifier(t, Webhook{Url: r.URL, Retries: &retries})
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web01"))
+	r.wait(t, 2)
+	r.quiet(t, 100*time.Millisecond)
+
+	//Retries = 0 means no retries, rather than the default
+	r2 := newWebhookReceiver(100)
+	defer r2.Close()
+	none := 0
+	n = testNotifier(t, Webhook{Url: r2.URL, Retries: &none})
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web01"))
+	r2.wait(t, 1)
+	r2.quiet(t, 100*time.Millisecond)
+}
+
+func TestNotifyQuiet(t *testing.T) {
+	r := newWebhookReceiver(0)
+	defer r.Close()
+	n := testNotifier(t, Webhook{Url: r.URL, Quiet: configDuration{time.Minute}})
+
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web01"))
+	r.wait(t, 1)
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web01"))
+	r.quiet(t, 50*time.Millisecond)
+	//Another server, or another event for the same server, is still sent
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web02"))
+	n.handle(serverEvent(haproxyctl.EventBackendDown, "web01"))
+	r.wait(t, 2)
+}
+
+func TestNotifyHold(t *testing.T) {
+	r := newWebhookReceiver(0)
+	defer r.Close()
+	n := testNotifier(t, Webhook{Url: r.URL, Events: []string{"server_down", "server_up"}, Hold: configDuration{50 * time.Millisecond}})
+
+	//A failure that recovers within Hold is not sent, and nor is its recovery
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web01"))
+	n.handle(serverEvent(haproxyctl.EventServerUp, "web01"))
+	r.quiet(t, 150*time.Millisecond)
+
+	//One that does not recover is sent once Hold has passed
+	start := time.Now()
+	n.handle(serverEvent(haproxyctl.EventServerDown, "web02"))
+	r.wait(t, 1)
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("sent after %v, want it held for 50ms", waited)
+	}
+	if !strings.Contains(r.posts()[0], "web02") {
+		t.Errorf("got %v", r.posts())
+	}
+}
+
+func TestNotifyHidesURL(t *testing.T) {
+	//Nothing listens on a closed port, so the POST fails
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	n := testNotifier(t, Webhook{Url: "http://" + addr + "/hooks/s3cret-token?key=s3cret-key"})
+	err = n.post([]byte("{}"))
+	if err == nil {
+		t.Fatal("the POST did not fail")
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("the error has the webhook URL in it: %v", err)
+	}
+}
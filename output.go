@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats accepted by -output
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputJSONL = "jsonl"
+	OutputCSV   = "csv"
+	OutputYAML  = "yaml"
+	OutputTSV   = "tsv"
+)
+
+var outputFormats = []string{OutputTable, OutputJSON, OutputJSONL, OutputCSV, OutputYAML, OutputTSV}
+
+func validOutputFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// column is a single field of a command's output
+type column struct {
+	Key    string                            // Stable field name used by the machine-readable formats
+	Header string                            // Heading used by the table format
+	Value  func(row interface{}) interface{} // Value used by the machine-readable formats
+	Text   func(row interface{}) string      // Optional text used by the table format, instead of Value
+}
+
+// report is the output of a command, which can be written in any of the output formats
+type report struct {
+	Columns  []column
+	Rows     []interface{}
+	Failures []string // Errors shown in the rows, which make the command exit non-zero once they are written
+}
+
+// fail records that a row of the report is the error from a load balancer
+func (r *report) fail(lb string, err error) {
+	r.Failures = append(r.Failures, fmt.Sprintf("%v: %v", lb, err))
+}
+
+// Write writes the report in the given output format
+func (r *report) Write(w io.Writer, format string) error {
+	switch format {
+	case OutputTable:
+		return r.writeTable(w)
+	case OutputJSON:
+		return r.writeJSON(w)
+	case OutputJSONL:
+		return r.writeJSONL(w)
+	case OutputCSV:
+		return r.writeCSV(w, ',')
+	case OutputTSV:
+		return r.writeCSV(w, '\t')
+	case OutputYAML:
+		return r.writeYAML(w)
+	}
+	return fmt.Errorf("unknown output format %v", format)
+}
+
+func (r *report) writeTable(w io.Writer) error {
+	table := tablewriter.NewWriter(w)
+	var headers []string
+	for _, c := range r.Columns {
+		headers = append(headers, c.Header)
+	}
+	table.SetHeader(headers)
+
+	for _, row := range r.Rows {
+		var cells []string
+		for _, c := range r.Columns {
+			if c.Text != nil {
+				cells = append(cells, c.Text(row))
+				continue
+			}
+			cells = append(cells, formatText(c.Value(row)))
+		}
+		table.Append(cells)
+	}
+
+	table.Render()
+	return nil
+}
+
+// record returns a row as an ordered set of keys and values
+func (r *report) record(row interface{}) yaml.MapSlice {
+	var rec yaml.MapSlice
+	for _, c := range r.Columns {
+		rec = append(rec, yaml.MapItem{Key: c.Key, Value: c.Value(row)})
+	}
+	return rec
+}
+
+// marshalRecord encodes a record as a JSON object, keeping its keys in column order
+func marshalRecord(rec yaml.MapSlice) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, item := range rec {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(item.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	var records []json.RawMessage
+	for _, row := range r.Rows {
+		rec, err := marshalRecord(r.record(row))
+		if err != nil {
+			return err
+		}
+		records = append(records, rec)
+	}
+	if records == nil {
+		records = []json.RawMessage{}
+	}
+
+	out, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func (r *report) writeJSONL(w io.Writer) error {
+	for _, row := range r.Rows {
+		rec, err := marshalRecord(r.record(row))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *report) writeCSV(w io.Writer, separator rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = separator
+
+	var headers []string
+	for _, c := range r.Columns {
+		headers = append(headers, c.Key)
+	}
+	if err := cw.Write(headers); err != nil {
+		return err
+	}
+
+	for _, row := range r.Rows {
+		var cells []string
+		for _, c := range r.Columns {
+			cells = append(cells, formatText(c.Value(row)))
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r *report) writeYAML(w io.Writer) error {
+	records := []yaml.MapSlice{}
+	for _, row := range r.Rows {
+		records = append(records, r.record(row))
+	}
+	out, err := yaml.Marshal(records)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// formatText formats a machine-readable value as plain text, for tables and CSV
+func formatText(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case []string:
+		return strings.Join(x, ",")
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// errorValue returns the message of an error, or nil if there was no error
+func errorValue(err error) interface{} {
+	if err == nil {
+		return nil
+	}
+	return err.Error()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encrypted secrets are written as enc:v1:<method>:<base64 of salt, nonce and ciphertext>. The method is key,
+// for a key file, or passphrase.
+const (
+	secretPrefix     = "enc:v1:"
+	secretKeyFile    = "key"
+	secretPassphrase = "passphrase"
+	secretSaltSize   = 16
+	passphraseIter   = 600000
+	passphraseEnv    = "HAPROXYCTL_PASSPHRASE"
+	keyFileEnv       = "HAPROXYCTL_KEY_FILE"
+	secretKeySize    = 32
+)
+
+// isEncrypted returns true if a password is a blob from secret encrypt
+func isEncrypted(s string) bool {
+	return strings.HasPrefix(s, secretPrefix)
+}
+
+// secretKeys gets the keys used to encrypt and decrypt secrets, only reading the key file or passphrase
+// once they are needed
+type secretKeys struct {
+	keyFile        string
+	passphrase     string
+	fileKey        []byte
+	passphraseKeys map[string][]byte // Keys derived from the passphrase, by salt
+}
+
+// newSecretKeys finds the key file, from $HAPROXYCTL_KEY_FILE or KeyFile in the configuration, and the
+// passphrase from $HAPROXYCTL_PASSPHRASE
+func newSecretKeys(c *HAProxyCtlConfig) *secretKeys {
+	k := &secretKeys{keyFile: c.KeyFile, passphrase: os.Getenv(passphraseEnv)}
+	if env := os.Getenv(keyFileEnv); env != "" {
+		k.keyFile = env
+	}
+	return k
+}
+
+// key returns the AES key for a method, using salt for passphrases
+func (k *secretKeys) key(method string, salt []byte) ([]byte, error) {
+	switch method {
+	case secretKeyFile:
+		if k.fileKey == nil {
+			if k.keyFile == "" {
+				return nil, fmt.Errorf("it was encrypted with a key file, but no key file is set (use KeyFile in the configuration or $%v)", keyFileEnv)
+			}
+			data, err := ioutil.ReadFile(expandHome(k.keyFile))
+			if err != nil {
+				return nil, fmt.Errorf("cannot read key file: %v", err)
+			}
+			if len(data) == 0 {
+				return nil, fmt.Errorf("key file %v is empty", k.keyFile)
+			}
+			sum := sha256.Sum256(data)
+			k.fileKey = sum[:]
+		}
+		return k.fileKey, nil
+	case secretPassphrase:
+		if k.passphrase == "" {
+			return nil, fmt.Errorf("it was encrypted with a passphrase, but $%v is not set", passphraseEnv)
+		}
+		//Deriving a key is slow on purpose, and the load balancers found in a group all share its password, so
+		//each salt's key is only derived once
+		if key, ok := k.passphraseKeys[string(salt)]; ok {
+			return key, nil
+		}
+		if k.passphraseKeys == nil {
+			k.passphraseKeys = make(map[string][]byte)
+		}
+		key := pbkdf2.Key([]byte(k.passphrase), salt, passphraseIter, secretKeySize, sha256.New)
+		k.passphraseKeys[string(salt)] = key
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown encryption method %v", method)
+}
+
+// encryptSecret encrypts a secret with AES-GCM
+func encryptSecret(plaintext string, method string, keys *secretKeys) (string, error) {
+	salt := make([]byte, secretSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := keys.key(method, salt)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	blob := append(salt, nonce...)
+	blob = gcm.Seal(blob, nonce, []byte(plaintext), []byte(method))
+	return secretPrefix + method + ":" + base64.StdEncoding.EncodeToString(blob), nil
+}
+
+// decryptSecret decrypts a blob from encryptSecret. Errors never include the blob or the secret.
+func decryptSecret(blob string, keys *secretKeys) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(blob, secretPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("the encrypted password is not in the format of secret encrypt")
+	}
+	method := parts[0]
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("the encrypted password is not valid base64")
+	}
+	if len(data) < secretSaltSize {
+		return "", fmt.Errorf("the encrypted password is too short")
+	}
+
+	salt := data[:secretSaltSize]
+	key, err := keys.key(method, salt)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	data = data[secretSaltSize:]
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("the encrypted password is too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(method))
+	if err != nil {
+		what := "passphrase"
+		if method == secretKeyFile {
+			what = "key file"
+		}
+		return "", fmt.Errorf("cannot decrypt the password, the %v is wrong or it has been changed", what)
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// runSecretEncrypt encrypts a password to use in the configuration file
+func runSecretEncrypt(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandSecret + " encrypt")
+	keyFile := flags.String("key-file", "", "Encrypt with this key file, instead of KeyFile in the configuration")
+	passphrase := flags.Bool("passphrase", false, "Encrypt with a passphrase from $"+passphraseEnv+" or the terminal, instead of a key file")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	keys := newSecretKeys(c)
+	if *keyFile != "" {
+		keys.keyFile = *keyFile
+	}
+	method := secretKeyFile
+	if *passphrase || keys.keyFile == "" {
+		method = secretPassphrase
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	if method == secretPassphrase && keys.passphrase == "" {
+		first, err := readSecretLine(in, "Passphrase: ")
+		if err != nil {
+			return err
+		}
+		second, err := readSecretLine(in, "Passphrase again: ")
+		if err != nil {
+			return err
+		}
+		if first != second {
+			return fmt.Errorf("the passphrases do not match")
+		}
+		if first == "" {
+			return fmt.Errorf("the passphrase cannot be empty")
+		}
+		keys.passphrase = first
+	}
+
+	secret, err := readSecretLine(in, "Password to encrypt: ")
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("nothing to encrypt")
+	}
+
+	blob, err := encryptSecret(secret, method, keys)
+	if err != nil {
+		return err
+	}
+	fmt.Println(blob)
+	return nil
+}
+
+// readSecretLine reads a line from stdin without echoing it, prompting on stderr if stdin is a terminal
+func readSecretLine(in *bufio.Reader, prompt string) (string, error) {
+	if saved, err := stty("-g"); err == nil {
+		fmt.Fprint(os.Stderr, prompt)
+		stty("-echo")
+		defer func() {
+			stty(strings.TrimSpace(saved))
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("cannot read from stdin: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeKeyFile(t *testing.T, name, key string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	tests := []struct {
+		method string
+		keys   *secretKeys
+	}{
+		{secretKeyFile, &secretKeys{keyFile: writeKeyFile(t, "key", "a key file")}},
+		{secretPassphrase, &secretKeys{passphrase: "correct horse"}},
+	}
+	for _, tt := range tests {
+		blob, err := encryptSecret("s3cret:pa$$word", tt.method, tt.keys)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.method, err)
+		}
+		if !isEncrypted(blob) || !strings.HasPrefix(blob, secretPrefix+tt.method+":") {
+			t.Errorf("%v: got %v, want an %v%v: blob", tt.method, blob, secretPrefix, tt.method)
+		}
+		if strings.Contains(blob, "s3cret") {
+			t.Errorf("%v: the blob has the secret in it", tt.method)
+		}
+		got, err := decryptSecret(blob, tt.keys)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.method, err)
+		}
+		if got != "s3cret:pa$$word" {
+			t.Errorf("%v: got %q back", tt.method, got)
+		}
+	}
+}
+
+func TestSecretWrongKey(t *testing.T) {
+	tests := []struct {
+		method string
+		keys   *secretKeys
+		wrong  *secretKeys
+		err    string
+	}{
+		{secretKeyFile, &secretKeys{keyFile: writeKeyFile(t, "key", "a key file")},
+			&secretKeys{keyFile: writeKeyFile(t, "other", "another key file")}, "the key file is wrong"},
+		{secretPassphrase, &secretKeys{passphrase: "correct horse"},
+			&secretKeys{passphrase: "battery staple"}, "the passphrase is wrong"},
+		{secretKeyFile, &secretKeys{keyFile: writeKeyFile(t, "key", "a key file")},
+			&secretKeys{}, "no key file is set"},
+		{secretPassphrase, &secretKeys{passphrase: "correct horse"},
+			&secretKeys{}, "$" + passphraseEnv + " is not set"},
+	}
+	for _, tt := range tests {
+		blob, err := encryptSecret("s3cret", tt.method, tt.keys)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.method, err)
+		}
+		_, err = decryptSecret(blob, tt.wrong)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: got %v, want an error containing %v", tt.method, err, tt.err)
+		}
+		if err != nil && strings.Contains(err.Error(), "s3cret") {
+			t.Errorf("%v: the error has the secret in it: %v", tt.method, err)
+		}
+	}
+}
+
+func TestSecretTampered(t *testing.T) {
+	keys := &secretKeys{keyFile: writeKeyFile(t, "key", "a key file")}
+	blob, err := encryptSecret("s3cret", secretKeyFile, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.TrimPrefix(blob, secretPrefix+secretKeyFile+":")
+	tests := []string{
+		secretPrefix + "nosuchmethod:" + data,
+		secretPrefix + secretKeyFile + ":" + data[:len(data)-4] + "AAA=",
+		secretPrefix + secretKeyFile + ":not base64",
+		secretPrefix + secretKeyFile + ":AAAA",
+		secretPrefix + secretKeyFile,
+	}
+	for _, tampered := range tests {
+		if got, err := decryptSecret(tampered, keys); err == nil {
+			t.Errorf("%v: decrypted to %q", tampered, got)
+		}
+	}
+}
+
+func TestSecretPassphraseCache(t *testing.T) {
+	keys := &secretKeys{passphrase: "correct horse"}
+	var blobs []string
+	for _, secret := range []string{"one", "two"} {
+		blob, err := encryptSecret(secret, secretPassphrase, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, blob, blob)
+	}
+
+	//A new set of keys, as when the configuration is loaded, derives one key for each salt
+	keys = &secretKeys{passphrase: "correct horse"}
+	for _, blob := range blobs {
+		if _, err := decryptSecret(blob, keys); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(keys.passphraseKeys) != 2 {
+		t.Errorf("got %v keys derived, want 2", len(keys.passphraseKeys))
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// lbSelector picks a subset of the load balancers, by name, group or tag
+type lbSelector struct {
+	names []*haproxyctl.Pattern // Load balancer names or groups, any of which may match
+	tags  []tagCondition        // Tags that must all match
+	spec  string
+}
+
+// tagCondition is a single key=value or key!=value of a -select
+type tagCondition struct {
+	key    string
+	value  *haproxyctl.Pattern
+	negate bool
+}
+
+// parseLBSelector parses -lb, a comma-separated list of load balancer names or groups, and -select, a
+// comma-separated list of tag conditions such as dc=ny,env!=dev. Names and tag values can be patterns. It
+// returns nil if both are empty.
+func parseLBSelector(lb, sel string) (*lbSelector, error) {
+	if strings.TrimSpace(lb) == "" && strings.TrimSpace(sel) == "" {
+		return nil, nil
+	}
+	s := &lbSelector{}
+
+	var specs []string
+	if lb != "" {
+		specs = append(specs, "-lb "+lb)
+		for _, name := range strings.Split(lb, ",") {
+			p, err := haproxyctl.ParsePattern(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			s.names = append(s.names, p)
+		}
+	}
+
+	if sel != "" {
+		specs = append(specs, "-select "+sel)
+		for _, cond := range strings.Split(sel, ",") {
+			c := tagCondition{}
+			parts := strings.SplitN(cond, "!=", 2)
+			if len(parts) == 2 {
+				c.negate = true
+			} else {
+				parts = strings.SplitN(cond, "=", 2)
+			}
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+				return nil, fmt.Errorf("invalid -select condition %v, must be key=value or key!=value", cond)
+			}
+			c.key = strings.TrimSpace(parts[0])
+			p, err := haproxyctl.ParsePattern(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, err
+			}
+			c.value = p
+			s.tags = append(s.tags, c)
+		}
+	}
+
+	s.spec = strings.Join(specs, " ")
+	return s, nil
+}
+
+// Match returns true if the load balancer is selected
+func (s *lbSelector) Match(lb *LoadBalancer) bool {
+	if len(s.names) > 0 {
+		found := false
+		for _, p := range s.names {
+			if p.Match(lb.Name) {
+				found = true
+				break
+			}
+			for _, g := range lb.Groups {
+				if p.Match(g) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, c := range s.tags {
+		value, ok := lb.tag(c.key)
+		if ok && c.value.Match(value) == c.negate {
+			return false
+		}
+		if !ok && !c.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the selector as it was given on the command line
+func (s *lbSelector) String() string {
+	return s.spec
+}
+
+// tag returns the value of one of the load balancer's tags, matching the key regardless of case
+func (lb *LoadBalancer) tag(key string) (string, bool) {
+	for k, v := range lb.Tags {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// selectLoadBalancers returns a copy of the configuration with only the selected load balancers. The copy does not
+// look for load balancers in LoadBalancerGroups again.
+func (c *HAProxyCtlConfig) selectLoadBalancers(sel *lbSelector) *HAProxyCtlConfig {
+	lbs := c.loadBalancers()
+	discoveryLock.Lock()
+	selected := *c
+	discoveryLock.Unlock()
+	selected.discovery = nil
+	selected.LoadBalancers = nil
+	for i := range lbs {
+		if sel.Match(&lbs[i]) {
+			selected.LoadBalancers = append(selected.LoadBalancers, lbs[i])
+		}
+	}
+	return &selected
+}
+
+// applySelector keeps only the load balancers selected by -lb and -select, including those read from
+// -from-file, which are matched by name alone as they have no groups or tags. It is an error if none are left.
+func (c *HAProxyCtlConfig) applySelector(lb, sel string) error {
+	selector, err := parseLBSelector(lb, sel)
+	if err != nil || selector == nil {
+		return err
+	}
+
+	if c.offline != nil {
+		var offline []haproxyctl.FleetStats
+		for _, l := range c.offline {
+			if selector.Match(&LoadBalancer{Name: l.Name}) {
+				offline = append(offline, l)
+			}
+		}
+		if len(offline) == 0 {
+			return fmt.Errorf("no load balancer in %v matches %v", *fromFile, selector)
+		}
+		c.offline = offline
+		return nil
+	}
+
+	c.LoadBalancers = c.selectLoadBalancers(selector).LoadBalancers
+	if c.discovery != nil {
+		c.discovery.selector = selector
+	}
+	if len(c.LoadBalancers) == 0 {
+		return fmt.Errorf("no load balancer matches %v", selector)
+	}
+	return nil
+}
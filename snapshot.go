@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// snapshotVersion is the version of the snapshot file format, which is increased if it changes incompatibly
+const snapshotVersion = 1
+
+// snapshot is the raw statistics of every load balancer at one point in time, as saved by snapshot save
+type snapshot struct {
+	Version       int          `json:"version"`
+	Time          time.Time    `json:"time"`
+	Host          string       `json:"host"`   // Host that took the snapshot
+	Config        string       `json:"config"` // Configuration file the load balancers came from
+	LoadBalancers []snapshotLB `json:"loadbalancers"`
+}
+
+// snapshotLB is the statistics of a single load balancer, in the CSV HAProxy sent them in
+type snapshotLB struct {
+	Name  string      `json:"name"`
+	Url   string      `json:"url"`
+	Time  time.Time   `json:"time"`
+	Error interface{} `json:"error"`
+	Stats string      `json:"stats"`
+
+	parsed *haproxyctl.Statistics
+}
+
+// defaultDiffCounters are the counters that snapshot diff shows the change in, unless told otherwise
+const defaultDiffCounters = "stot,hrsp_5xx,econ,eresp,chkfail,chkdown"
+
+// snapshotSave writes the raw statistics of every load balancer to a timestamped file
+func snapshotSave(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandSnapshot + " save")
+	dir := flags.String("dir", ".", "Directory to save the snapshot in")
+	file := flags.String("file", "", "File to save the snapshot to, instead of a timestamped file in -dir")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	s := snapshot{Version: snapshotVersion, Time: time.Now().UTC(), Config: strings.Join(c.files, ", ")}
+	s.Host, _ = os.Hostname()
+	lbs := c.loadBalancers()
+	for i, fs := range newFleet(lbs).GetStats() {
+		s.LoadBalancers = append(s.LoadBalancers, snapshotLB{
+			Name:  fs.Name,
+			Url:   redactURL(lbs[i].Url, false),
+			Time:  fs.Time.UTC(),
+			Error: errorValue(fs.Err),
+			Stats: string(fs.Raw),
+		})
+		if fs.Err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", fs.Name, fs.Err)
+		}
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := *file
+	if path == "" {
+		path = filepath.Join(*dir, fmt.Sprintf("haproxyctl-snapshot-%v.json", s.Time.Format("20060102T150405Z")))
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	fmt.Println(path)
+	return nil
+}
+
+// loadSnapshot reads a snapshot file and parses the statistics in it
+func loadSnapshot(path string) (*snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSnapshot(path, data)
+}
+
+// parseSnapshot parses a snapshot, and the statistics in it. The path is only used in errors.
+func parseSnapshot(path string, data []byte) (*snapshot, error) {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("%v: unsupported snapshot version %v", path, s.Version)
+	}
+	for i := range s.LoadBalancers {
+		lb := &s.LoadBalancers[i]
+		if lb.Error != nil {
+			continue
+		}
+		var err error
+		if lb.parsed, err = haproxyctl.ParseStatistics([]byte(lb.Stats)); err != nil {
+			return nil, fmt.Errorf("%v: %v: %v", path, lb.Name, err)
+		}
+	}
+	return &s, nil
+}
+
+// Kinds of change shown by snapshot diff
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+	diffCounter = "counter"
+)
+
+// diffRow is a single difference between two snapshots
+type diffRow struct {
+	LB      string
+	Kind    string
+	Type    string // frontend, backend, server or loadbalancer
+	Backend string
+	Server  string
+	Field   string
+	From    interface{}
+	To      interface{}
+	Delta   interface{}
+	Err     error
+}
+
+var diffColumns = []column{
+	{Key: "lb", Header: "LB", Value: func(r interface{}) interface{} { return r.(*diffRow).LB }},
+	{Key: "kind", Header: "Change", Value: func(r interface{}) interface{} { return r.(*diffRow).Kind }},
+	{Key: "type", Header: "Type", Value: func(r interface{}) interface{} { return r.(*diffRow).Type }},
+	{Key: "backend", Header: "Backend", Value: func(r interface{}) interface{} { return r.(*diffRow).Backend }},
+	{Key: "server", Header: "Server", Value: func(r interface{}) interface{} { return r.(*diffRow).Server }},
+	{Key: "field", Header: "Field", Value: func(r interface{}) interface{} { return r.(*diffRow).Field }},
+	{Key: "from", Header: "From", Value: func(r interface{}) interface{} { return r.(*diffRow).From }},
+	{Key: "to", Header: "To", Value: func(r interface{}) interface{} { return r.(*diffRow).To }},
+	{Key: "delta", Header: "Delta", Value: func(r interface{}) interface{} { return r.(*diffRow).Delta }},
+	{Key: "error", Header: "Error", Value: func(r interface{}) interface{} { return errorValue(r.(*diffRow).Err) }},
+}
+
+// snapshotDiff shows what changed on each load balancer between two snapshots
+func snapshotDiff(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandSnapshot + " diff")
+	counterSpec := flags.String("counters", defaultDiffCounters, "Comma-separated counters to show the change in, or all or none")
+	addOutputFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("snapshot diff needs two snapshot files")
+	}
+	counters, err := diffCounters(*counterSpec)
+	if err != nil {
+		return err
+	}
+
+	a, err := loadSnapshot(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := loadSnapshot(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	return writeReport(&report{Columns: diffColumns, Rows: diffSnapshots(a, b, counters)})
+}
+
+// diffCounters parses the -counters list of snapshot diff
+func diffCounters(spec string) ([]haproxyctl.Field, error) {
+	var counters []haproxyctl.Field
+	switch strings.ToLower(spec) {
+	case "none", "":
+		return nil, nil
+	case "all":
+		for _, f := range haproxyctl.StatisticFields() {
+			if f.Counter {
+				counters = append(counters, f)
+			}
+		}
+		return counters, nil
+	}
+	for _, name := range strings.Split(spec, ",") {
+		f, ok := haproxyctl.LookupField(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown statistic field %v", name)
+		}
+		if !f.Counter {
+			return nil, fmt.Errorf("%v is not a counter", name)
+		}
+		counters = append(counters, f)
+	}
+	return counters, nil
+}
+
+// diffSnapshots lists the load balancers, proxies and servers added or removed between two snapshots, the
+// servers whose status or weight changed, and the change in each of the counters
+func diffSnapshots(a, b *snapshot, counters []haproxyctl.Field) []interface{} {
+	var rows []interface{}
+
+	before := make(map[string]*snapshotLB)
+	for i := range a.LoadBalancers {
+		before[a.LoadBalancers[i].Name] = &a.LoadBalancers[i]
+	}
+	after := make(map[string]bool)
+
+	for i := range b.LoadBalancers {
+		lbB := &b.LoadBalancers[i]
+		after[lbB.Name] = true
+		lbA, ok := before[lbB.Name]
+		switch {
+		case !ok:
+			rows = append(rows, &diffRow{LB: lbB.Name, Kind: diffAdded, Type: "loadbalancer"})
+		case lbA.parsed == nil:
+			rows = append(rows, &diffRow{LB: lbB.Name, Err: fmt.Errorf("in the first snapshot: %v", lbA.Error)})
+		case lbB.parsed == nil:
+			rows = append(rows, &diffRow{LB: lbB.Name, Err: fmt.Errorf("in the second snapshot: %v", lbB.Error)})
+		default:
+			rows = append(rows, diffStatistics(lbB.Name, lbA.parsed, lbB.parsed, counters)...)
+		}
+	}
+	for _, lb := range a.LoadBalancers {
+		if !after[lb.Name] {
+			rows = append(rows, &diffRow{LB: lb.Name, Kind: diffRemoved, Type: "loadbalancer"})
+		}
+	}
+
+	return rows
+}
+
+// diffStatistics compares the statistics of one load balancer from two snapshots
+func diffStatistics(lb string, a, b *haproxyctl.Statistics, counters []haproxyctl.Field) []interface{} {
+	var rows []interface{}
+	row := func(kind string, s *haproxyctl.Statistic) *diffRow {
+		r := &diffRow{LB: lb, Kind: kind, Type: s.Type.String(), Backend: s.BackendName}
+		if s.Type == haproxyctl.Server {
+			r.Server = s.FrontendName
+		}
+		return r
+	}
+
+	before := make(map[string]*haproxyctl.Statistic)
+	for i := range *a {
+		before[haproxyctl.StatisticKey(&(*a)[i])] = &(*a)[i]
+	}
+	seen := make(map[string]bool)
+
+	for i := range *b {
+		cur := &(*b)[i]
+		key := haproxyctl.StatisticKey(cur)
+		seen[key] = true
+		prev, ok := before[key]
+		if !ok {
+			rows = append(rows, row(diffAdded, cur))
+			continue
+		}
+
+		if cur.Type == haproxyctl.Server || cur.Type == haproxyctl.Backend {
+			if prev.Status != cur.Status {
+				r := row(diffChanged, cur)
+				r.Field, r.From, r.To = "status", prev.Status, cur.Status
+				rows = append(rows, r)
+			}
+			if prev.Weight != cur.Weight {
+				r := row(diffChanged, cur)
+				r.Field, r.From, r.To, r.Delta = "weight", prev.Weight, cur.Weight, int64(cur.Weight)-int64(prev.Weight)
+				rows = append(rows, r)
+			}
+		}
+
+		for _, f := range counters {
+			from, to := f.Value(prev).(uint64), f.Value(cur).(uint64)
+			if from == to {
+				continue
+			}
+			r := row(diffCounter, cur)
+			r.Field, r.From, r.To, r.Delta = f.Stat, from, to, int64(to)-int64(from)
+			rows = append(rows, r)
+		}
+	}
+
+	for i := range *a {
+		if !seen[haproxyctl.StatisticKey(&(*a)[i])] {
+			rows = append(rows, row(diffRemoved, &(*a)[i]))
+		}
+	}
+
+	return rows
+}
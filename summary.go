@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// summaryRow is a backend rolled up across every load balancer, or the error from a load balancer whose
+// statistics could not be retrieved
+type summaryRow struct {
+	LB  string
+	Err error
+	haproxyctl.BackendSummary
+}
+
+// summaryColumn creates a column for a backend's summary. Rows that only carry a load balancer error have no value.
+func summaryColumn(key, header string, value func(s *haproxyctl.BackendSummary) interface{}) column {
+	return column{
+		Key:    key,
+		Header: header,
+		Value: func(r interface{}) interface{} {
+			if r.(*summaryRow).Err != nil {
+				return nil
+			}
+			return value(&r.(*summaryRow).BackendSummary)
+		},
+	}
+}
+
+// lbHealth is the health of a backend on each load balancer. It is shown as "LB01 2/2, LB02 1/2" in tables and
+// CSV, and as a list of objects in JSON and YAML.
+type lbHealth []haproxyctl.BackendHealth
+
+func (h lbHealth) String() string {
+	var parts []string
+	for _, x := range h {
+		parts = append(parts, fmt.Sprintf("%v %v/%v", x.Name, x.ServersUp, x.ServersTotal))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h lbHealth) records() []map[string]interface{} {
+	records := []map[string]interface{}{}
+	for _, x := range h {
+		records = append(records, map[string]interface{}{
+			"lb":            x.Name,
+			"status":        x.Status,
+			"servers_up":    x.ServersUp,
+			"servers_total": x.ServersTotal,
+			"scur":          x.SessionsCurrent,
+			"qcur":          x.QueueCurrent,
+			"hrsp_5xx":      x.HTTPResponse5xx,
+		})
+	}
+	return records
+}
+
+// MarshalJSON writes the health on each load balancer as a list of objects
+func (h lbHealth) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.records())
+}
+
+// MarshalYAML writes the health on each load balancer as a list of maps
+func (h lbHealth) MarshalYAML() (interface{}, error) {
+	return h.records(), nil
+}
+
+// lbError is the load balancer of an error row. It is written in the same shape as lbHealth, without the counts
+// that could not be retrieved.
+type lbError string
+
+func (e lbError) String() string {
+	return string(e)
+}
+
+func (e lbError) records() []map[string]interface{} {
+	return []map[string]interface{}{{"lb": string(e), "status": "ERROR"}}
+}
+
+// MarshalJSON writes the load balancer as a list of one object
+func (e lbError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.records())
+}
+
+// MarshalYAML writes the load balancer as a list of one map
+func (e lbError) MarshalYAML() (interface{}, error) {
+	return e.records(), nil
+}
+
+var summaryColumns = []column{
+	summaryColumn("backend", "Backend", func(s *haproxyctl.BackendSummary) interface{} { return s.Backend }),
+	{Key: "state", Header: "State",
+		Value: func(r interface{}) interface{} {
+			if r.(*summaryRow).Err != nil {
+				return "error"
+			}
+			return string(r.(*summaryRow).State)
+		},
+		Text: func(r interface{}) string {
+			switch {
+			case r.(*summaryRow).Err != nil:
+				return "ERROR"
+			case r.(*summaryRow).State == haproxyctl.BackendDown:
+				return "DOWN (NO SERVERS UP)"
+			case r.(*summaryRow).State == haproxyctl.BackendEmpty:
+				return "EMPTY (NO SERVERS)"
+			}
+			return strings.ToUpper(string(r.(*summaryRow).State))
+		},
+	},
+	{Key: "loadbalancers", Header: "Up/Total per LB", Value: func(r interface{}) interface{} {
+		if r.(*summaryRow).Err != nil {
+			return lbError(r.(*summaryRow).LB)
+		}
+		return lbHealth(r.(*summaryRow).LoadBalancers)
+	}},
+	summaryColumn("servers_up", "Up", func(s *haproxyctl.BackendSummary) interface{} { return s.ServersUp }),
+	summaryColumn("servers_total", "Total", func(s *haproxyctl.BackendSummary) interface{} { return s.ServersTotal }),
+	summaryColumn("scur", "Sessions", func(s *haproxyctl.BackendSummary) interface{} { return s.SessionsCurrent }),
+	summaryColumn("qcur", "Queue", func(s *haproxyctl.BackendSummary) interface{} { return s.QueueCurrent }),
+	summaryColumn("hrsp_5xx", "5xx", func(s *haproxyctl.BackendSummary) interface{} { return s.HTTPResponse5xx }),
+	{Key: "error", Header: "Error", Value: func(r interface{}) interface{} { return errorValue(r.(*summaryRow).Err) }},
+}
+
+// runSummary prints one line per backend, rolling up its servers across every load balancer
+func runSummary(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandSummary)
+	addOutputFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	backend, err := haproxyctl.ParsePattern(haproxyctl.AllBackends)
+	if flags.NArg() > 0 {
+		backend, err = haproxyctl.ParsePattern(flags.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	//Load balancers whose statistics could not be retrieved are listed first, as they are by get
+	stats := c.fleetStats()
+	output := &report{Columns: summaryColumns}
+	for _, s := range stats {
+		if s.Err != nil {
+			output.Rows = append(output.Rows, &summaryRow{LB: s.Name, Err: s.Err})
+			output.fail(s.Name, s.Err)
+		}
+	}
+	for _, s := range haproxyctl.Summarize(stats) {
+		if !backend.Match(s.Backend) {
+			continue
+		}
+		output.Rows = append(output.Rows, &summaryRow{BackendSummary: s})
+	}
+
+	return writeReport(output)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// templateFuncs are the helper functions available to -format templates
+var templateFuncs = template.FuncMap{
+	"bytes":    templateBytes,
+	"duration": templateDuration,
+	"ms":       templateMilliseconds,
+	"pad":      templatePad,
+	"lpad":     templateLeftPad,
+	"join":     strings.Join,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"json":     templateJSON,
+}
+
+// parseFormat parses a -format template, which is executed once for each row of output
+func parseFormat(format string) (*template.Template, error) {
+	return template.New("format").Funcs(templateFuncs).Parse(format)
+}
+
+// writeTemplate executes the template for each row of the report, with each on its own line
+func (r *report) writeTemplate(w io.Writer, tmpl *template.Template) error {
+	for _, row := range r.Rows {
+		if err := tmpl.Execute(w, row); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateBytes formats a byte count, such as 1.5 MiB
+func templateBytes(b uint64) string {
+	return formatBytes(b)
+}
+
+// templateDuration formats a duration. Plain numbers are taken to be seconds.
+func templateDuration(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case haproxyctl.Duration:
+		return x.String(), nil
+	case time.Duration:
+		return x.String(), nil
+	case uint64:
+		return (time.Duration(x) * time.Second).String(), nil
+	case int:
+		return (time.Duration(x) * time.Second).String(), nil
+	case float64:
+		return time.Duration(x * float64(time.Second)).String(), nil
+	}
+	return "", fmt.Errorf("duration: cannot format %T", v)
+}
+
+// templateMilliseconds formats a timing that HAProxy reports in milliseconds
+func templateMilliseconds(ms uint64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
+
+// templatePad pads a value with spaces on the right, to at least the given width
+func templatePad(width int, v interface{}) string {
+	return fmt.Sprintf("%-*v", width, v)
+}
+
+// templateLeftPad pads a value with spaces on the left, to at least the given width
+func templateLeftPad(width int, v interface{}) string {
+	return fmt.Sprintf("%*v", width, v)
+}
+
+func templateJSON(v interface{}) (string, error) {
+	out, err := json.Marshal(v)
+	return string(out), err
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+)
+
+// ANSI escape sequences used to draw the top screen
+const (
+	ansiReset       = "\x1b[0m"
+	ansiBold        = "\x1b[1m"
+	ansiReverse     = "\x1b[7m"
+	ansiRed         = "\x1b[31m"
+	ansiGreen       = "\x1b[32m"
+	ansiYellow      = "\x1b[33m"
+	ansiClear       = "\x1b[H\x1b[2J"
+	ansiAltScreen   = "\x1b[?1049h"
+	ansiMainScreen  = "\x1b[?1049l"
+	ansiHideCursor  = "\x1b[?25l"
+	ansiShowCursor  = "\x1b[?25h"
+	topChangeWindow = 3 // Number of refreshes a status change stays highlighted for
+)
+
+// topSortKeys are the fields that the s key cycles through. The first entry keeps HAProxy's own order.
+var topSortKeys = []string{"", "-scur", "-rate", "-req_rate", "-qcur", "status", "svname"}
+
+// topScreen is the state of the top view between refreshes
+type topScreen struct {
+	interval time.Duration
+	filter   *haproxyctl.Filter
+	sortKey  int
+	reverse  bool
+	prompt   *string // The filter being typed, while the / prompt is open
+	message  string
+
+	latest  []haproxyctl.FleetStats
+	status  map[string]string // Last seen status of each LB/backend/server
+	changed map[string]int    // Refreshes left to highlight a status change for
+}
+
+// runTop polls every load balancer and redraws a full-screen view of backends and servers until q is pressed
+func runTop(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandTop)
+	interval := flags.Duration("interval", 2*time.Second, "How often to poll the load balancers")
+	where := flags.String("where", "", "Only show statistics matching this expression")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	t := &topScreen{
+		interval: *interval,
+		status:   make(map[string]string),
+		changed:  make(map[string]int),
+	}
+	if *where != "" {
+		filter, err := haproxyctl.ParseFilter(*where)
+		if err != nil {
+			return err
+		}
+		t.filter = filter
+	}
+
+	restore := rawTerminal()
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer func() {
+		fmt.Print(ansiReset + ansiShowCursor + ansiMainScreen)
+		restore()
+	}()
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	//Only one refresh is made at a time, so ticks while the load balancers are slow to answer are skipped rather
+	//than piling up requests
+	refreshed := make(chan []haproxyctl.FleetStats, 1)
+	refreshing := false
+	refresh := func() {
+		if refreshing {
+			return
+		}
+		refreshing = true
+		go func() { refreshed <- c.fleetStats() }()
+	}
+	refresh()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case stats := <-refreshed:
+			refreshing = false
+			t.update(stats)
+			t.draw()
+		case <-ticker.C:
+			refresh()
+		case k, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+			if quit := t.key(k); quit {
+				return nil
+			}
+			t.draw()
+		}
+	}
+}
+
+// rawTerminal turns off line buffering and echo so that single key presses can be read, returning a function that
+// puts the terminal back. If the terminal cannot be changed, keys only arrive after Enter.
+func rawTerminal() func() {
+	saved, err := stty("-g")
+	if err != nil {
+		return func() {}
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return func() {}
+	}
+	return func() {
+		stty(strings.TrimSpace(saved))
+	}
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// terminalSize returns the number of rows and columns of the terminal, or 24x80 if it cannot be found
+func terminalSize() (int, int) {
+	out, err := stty("size")
+	if err == nil {
+		parts := strings.Fields(out)
+		if len(parts) == 2 {
+			rows, rerr := strconv.Atoi(parts[0])
+			cols, cerr := strconv.Atoi(parts[1])
+			if rerr == nil && cerr == nil && rows > 0 && cols > 0 {
+				return rows, cols
+			}
+		}
+	}
+	return 24, 80
+}
+
+// key handles a key press, returning true if top should exit
+func (t *topScreen) key(k byte) bool {
+	if t.prompt != nil {
+		switch k {
+		case '\r', '\n':
+			expr := strings.TrimSpace(*t.prompt)
+			t.prompt = nil
+			if expr == "" {
+				t.filter = nil
+				t.message = "filter cleared"
+				return false
+			}
+			filter, err := haproxyctl.ParseFilter(expr)
+			if err != nil {
+				t.message = err.Error()
+				return false
+			}
+			t.filter = filter
+			t.message = ""
+		case 27: //Escape
+			t.prompt = nil
+		case 127, 8: //Backspace
+			if p := *t.prompt; len(p) > 0 {
+				*t.prompt = p[:len(p)-1]
+			}
+		default:
+			if k >= 32 && k < 127 {
+				*t.prompt += string(k)
+			}
+		}
+		return false
+	}
+
+	switch k {
+	case 'q', 'Q', 3:
+		return true
+	case 's':
+		t.sortKey = (t.sortKey + 1) % len(topSortKeys)
+	case 'r':
+		t.reverse = !t.reverse
+	case '/':
+		prompt := ""
+		if t.filter != nil {
+			prompt = t.filter.String()
+		}
+		t.prompt = &prompt
+	case 'c':
+		t.filter = nil
+		t.message = "filter cleared"
+	}
+	return false
+}
+
+func topKey(lb string, s *haproxyctl.Statistic) string {
+	return lb + "/" + s.BackendName + "/" + s.FrontendName
+}
+
+// update records new statistics, and notes which rows have changed status since the last refresh
+func (t *topScreen) update(latest []haproxyctl.FleetStats) {
+	for k, n := range t.changed {
+		if n <= 1 {
+			delete(t.changed, k)
+			continue
+		}
+		t.changed[k] = n - 1
+	}
+
+	for _, l := range latest {
+		if l.Err != nil {
+			continue
+		}
+		for i := range *l.Stats {
+			s := &(*l.Stats)[i]
+			key := topKey(l.Name, s)
+			if previous, ok := t.status[key]; ok && previous != s.Status {
+				t.changed[key] = topChangeWindow
+			}
+			t.status[key] = s.Status
+		}
+	}
+	t.latest = latest
+}
+
+// topRow is a single line of the top view
+type topRow struct {
+	LB   string
+	Stat *haproxyctl.Statistic
+}
+
+func (t *topScreen) rows() []topRow {
+	var rows []topRow
+	for _, l := range t.latest {
+		if l.Err != nil {
+			continue
+		}
+		for i := range *l.Stats {
+			s := &(*l.Stats)[i]
+			if s.Type != haproxyctl.Backend && s.Type != haproxyctl.Server {
+				continue
+			}
+			if t.filter != nil && !t.filter.Match(s) {
+				continue
+			}
+			rows = append(rows, topRow{LB: l.Name, Stat: s})
+		}
+	}
+
+	if key := topSortKeys[t.sortKey]; key != "" {
+		keys, _ := haproxyctl.ParseSortKeys(key)
+		sort.SliceStable(rows, func(i, j int) bool {
+			if t.reverse {
+				return keys.Less(rows[j].Stat, rows[i].Stat)
+			}
+			return keys.Less(rows[i].Stat, rows[j].Stat)
+		})
+	} else if t.reverse {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	return rows
+}
+
+func statusColour(status string) string {
+	switch {
+	case strings.HasPrefix(status, "UP"), status == "OPEN":
+		return ansiGreen
+	case strings.HasPrefix(status, "DOWN"):
+		return ansiRed
+	case strings.HasPrefix(status, "MAINT"), strings.HasPrefix(status, "DRAIN"), strings.HasPrefix(status, "NOLB"):
+		return ansiYellow
+	}
+	return ""
+}
+
+func (t *topScreen) draw() {
+	height, width := terminalSize()
+	var buf bytes.Buffer
+	lines := 0
+	line := func(colour, text string) {
+		if lines >= height-1 {
+			return
+		}
+		if len(text) > width {
+			text = text[:width]
+		}
+		if colour != "" {
+			text = colour + text + ansiReset
+		}
+		buf.WriteString(text + "\r\n")
+		lines++
+	}
+
+	buf.WriteString(ansiClear)
+
+	sortName := topSortKeys[t.sortKey]
+	if sortName == "" {
+		sortName = "haproxy order"
+	}
+	if t.reverse {
+		sortName += " (reversed)"
+	}
+	filterName := "none"
+	if t.filter != nil {
+		filterName = t.filter.String()
+	}
+	line(ansiBold, fmt.Sprintf("haproxyctl top - %v - every %v - sort: %v - filter: %v",
+		time.Now().Format("15:04:05"), t.interval, sortName, filterName))
+	line("", "q quit  s sort  r reverse  / filter  c clear filter")
+
+	for _, l := range t.latest {
+		if l.Err != nil {
+			line(ansiRed, fmt.Sprintf("%v: %v", l.Name, l.Err))
+		}
+	}
+	line("", "")
+
+	line(ansiReverse, fmt.Sprintf("%-10v %-24v %-24v %-12v %7v %7v %8v %6v %-10v", "LB", "BACKEND", "SERVER",
+		"STATUS", "SCUR", "RATE", "REQ/S", "QCUR", "CHECK"))
+
+	for _, r := range t.rows() {
+		s := r.Stat
+		text := fmt.Sprintf("%-10v %-24v %-24v %-12v %7v %7v %8v %6v %-10v", r.LB, s.BackendName, s.FrontendName,
+			s.Status, s.SessionsCurrent, s.Rate, s.RequestRate, s.QueueCurrent, s.CheckStatus)
+		colour := statusColour(s.Status)
+		if s.Type == haproxyctl.Backend {
+			colour += ansiBold
+		}
+		if _, ok := t.changed[topKey(r.LB, s)]; ok {
+			colour += ansiReverse
+		}
+		line(colour, text)
+	}
+
+	for lines < height-1 {
+		line("", "")
+	}
+	switch {
+	case t.prompt != nil:
+		buf.WriteString("filter: " + *t.prompt)
+	case t.message != "":
+		buf.WriteString(t.message)
+	}
+
+	os.Stdout.Write(buf.Bytes())
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhenderson-so/haproxyctl/cmd/haproxyctl"
+	"gopkg.in/yaml.v2"
+)
+
+// Severities of configuration problems. Errors stop haproxyctl from running, warnings are only reported by
+// config validate.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// Checks that configuration problems are found by
+const (
+	checkUnknownKey  = "unknown_key"
+	checkURL         = "invalid_url"
+	checkDuplicate   = "duplicate_name"
+	checkCredentials = "credentials"
+	checkUnreachable = "unreachable"
+	checkNotAdmin    = "not_admin"
+	checkDiscovery   = "discovery"
+)
+
+// configProblem is a single problem found in the configuration
+type configProblem struct {
+	Severity string
+	Check    string
+	File     string // File the problem is in, if it is known
+	LB       string // Load balancer the problem is with, if any
+	Field    string // Key the problem is with, such as Url or LoadBalancers.Usrname
+	Message  string
+}
+
+func (p configProblem) Error() string {
+	var where []string
+	for _, s := range []string{p.File, p.LB, p.Field} {
+		if s != "" {
+			where = append(where, s)
+		}
+	}
+	if len(where) == 0 {
+		return p.Message
+	}
+	return strings.Join(where, ": ") + ": " + p.Message
+}
+
+// configProblems are all of the problems found in the configuration, which are returned together so that they
+// can all be fixed at once
+type configProblems []configProblem
+
+func (p configProblems) Error() string {
+	lines := []string{fmt.Sprintf("the configuration has %v problem(s), see haproxyctl config validate:", len(p))}
+	for _, problem := range p {
+		lines = append(lines, "    "+problem.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// errors returns only the problems that are errors
+func (p configProblems) errors() configProblems {
+	var errs configProblems
+	for _, problem := range p {
+		if problem.Severity == severityError {
+			errs = append(errs, problem)
+		}
+	}
+	return errs
+}
+
+// problem returns an error with a field of the load balancer
+func (lb *LoadBalancer) problem(check, field string, err error) configProblem {
+	return configProblem{Severity: severityError, Check: check, LB: lb.Name, Field: field, Message: err.Error()}
+}
+
+// parseStatsURL parses the URL of a load balancer's stats page, adding the / that haproxy;csv is added after if
+// it is missing. The URL is left out of errors, as it may have a password in it.
+func parseStatsURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("not a valid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("must be an http or https URL")
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("has no host")
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
+	}
+	return u, nil
+}
+
+// checkNames finds load balancers with the same name, which could not be told apart
+func (c *HAProxyCtlConfig) checkNames() configProblems {
+	var problems configProblems
+	seen := make(map[string]bool)
+	for i, lb := range c.LoadBalancers {
+		if lb.Name == "" {
+			problems = append(problems, configProblem{Severity: severityError, Check: checkDuplicate, Field: fmt.Sprintf("LoadBalancers[%v].Name", i), Message: "the load balancer has no name"})
+			continue
+		}
+		if seen[strings.ToLower(lb.Name)] {
+			problems = append(problems, lb.problem(checkDuplicate, "Name", fmt.Errorf("more than one load balancer is called %v", lb.Name)))
+		}
+		seen[strings.ToLower(lb.Name)] = true
+	}
+	return problems
+}
+
+// checkWebhooks finds webhooks that cannot be sent to
+func (c *HAProxyCtlConfig) checkWebhooks() configProblems {
+	var problems configProblems
+	for i, w := range c.Webhooks {
+		u, err := url.Parse(w.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, configProblem{Severity: severityError, Check: checkURL, Field: fmt.Sprintf("Webhooks[%v].Url", i), Message: "must be an http or https URL"})
+		}
+	}
+	return problems
+}
+
+// checkCredential finds a load balancer with only half of its credentials, or none at all
+func (lb *LoadBalancer) checkCredential() configProblems {
+	cred := lb.credential
+	switch {
+	case cred.Username == "" && cred.Password == "":
+		return configProblems{{Severity: severityWarning, Check: checkCredentials, LB: lb.Name, Message: "no username or password was found, so the stats page is used without authentication"}}
+	case cred.Password == "":
+		return configProblems{lb.problem(checkCredentials, "Password", fmt.Errorf("the username is from %v, but no password was found", cred.usernameSource))}
+	case cred.Username == "":
+		return configProblems{lb.problem(checkCredentials, "Username", fmt.Errorf("the password is from %v, but no username was found", cred.passwordSource))}
+	}
+	return nil
+}
+
+// yamlUnknownKey matches the errors yaml.UnmarshalStrict gives for unknown keys
+var yamlUnknownKey = regexp.MustCompile(`^line (\d+): field (.+) not found in type`)
+
+// unknownYAMLKeys splits the errors from yaml.UnmarshalStrict into unknown keys, which are problems, and any
+// other errors
+func unknownYAMLKeys(path string, err error) (configProblems, error) {
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return nil, err
+	}
+	var problems configProblems
+	var others []string
+	for _, e := range typeErr.Errors {
+		if m := yamlUnknownKey.FindStringSubmatch(e); m != nil {
+			problems = append(problems, configProblem{Severity: severityError, Check: checkUnknownKey, File: path, Field: m[2], Message: "unknown key on line " + m[1]})
+		} else {
+			others = append(others, e)
+		}
+	}
+	if len(others) > 0 {
+		return nil, fmt.Errorf("%v", strings.Join(others, ", "))
+	}
+	return problems, nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// unknownJSONKeys finds the keys in decoded JSON that are not fields of t. Like encoding/json, keys are matched
+// regardless of case.
+func unknownJSONKeys(v interface{}, t reflect.Type, prefix string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f, ok := t.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, k) })
+			if !ok || f.PkgPath != "" || f.Tag.Get("json") == "-" {
+				unknown = append(unknown, prefix+k)
+				continue
+			}
+			unknown = append(unknown, unknownJSONKeys(m[k], f.Type, prefix+f.Name+".")...)
+		}
+	case reflect.Slice:
+		if items, ok := v.([]interface{}); ok {
+			for i, item := range items {
+				unknown = append(unknown, unknownJSONKeys(item, t.Elem(), fmt.Sprintf("%v[%v].", strings.TrimSuffix(prefix, "."), i))...)
+			}
+		}
+	}
+	return unknown
+}
+
+// decodeJSONConfig decodes a JSON configuration file, and returns its unknown keys as problems
+func decodeJSONConfig(path string, data []byte, c *HAProxyCtlConfig) (configProblems, error) {
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	var problems configProblems
+	for _, key := range unknownJSONKeys(raw, reflect.TypeOf(c), "") {
+		problems = append(problems, configProblem{Severity: severityError, Check: checkUnknownKey, File: path, Field: key, Message: "unknown key"})
+	}
+	return problems, nil
+}
+
+var configProblemColumns = []column{
+	{Key: "severity", Header: "Severity", Value: func(r interface{}) interface{} { return r.(*configProblem).Severity }},
+	{Key: "check", Header: "Check", Value: func(r interface{}) interface{} { return r.(*configProblem).Check }},
+	{Key: "file", Header: "File", Value: func(r interface{}) interface{} { return r.(*configProblem).File }},
+	{Key: "lb", Header: "LB", Value: func(r interface{}) interface{} { return r.(*configProblem).LB }},
+	{Key: "field", Header: "Field", Value: func(r interface{}) interface{} { return r.(*configProblem).Field }},
+	{Key: "message", Header: "Message", Value: func(r interface{}) interface{} { return r.(*configProblem).Message }},
+}
+
+// runConfigValidate checks the configuration, and that every load balancer's stats page can be reached and
+// allows actions, and lists any problems. It fails if any of them are errors.
+func runConfigValidate(c *HAProxyCtlConfig, args []string) error {
+	flags := newFlagSet(CommandConfig + " validate")
+	noConnect := flags.Bool("no-connect", false, "Only check the configuration, without connecting to the load balancers")
+	timeout := flags.Duration("timeout", 10*time.Second, "How long to wait for each load balancer")
+	addOutputFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	problems := c.processInit()
+	if !*noConnect {
+		problems = append(problems, c.checkStatsPages(problems, *timeout)...)
+	}
+
+	output := &report{Columns: configProblemColumns}
+	for i := range problems {
+		output.Rows = append(output.Rows, &problems[i])
+	}
+	if len(problems) == 0 && *outputFormat == OutputTable && *formatTemplate == "" {
+		fmt.Printf("%v load balancer(s) checked, no problems found\n", len(c.loadBalancers()))
+	} else if err := writeReport(output); err != nil {
+		return err
+	}
+
+	if errs := problems.errors(); len(errs) > 0 {
+		return fmt.Errorf("the configuration has %v error(s)", len(errs))
+	}
+	return nil
+}
+
+// checkStatsPages finds load balancers whose stats page cannot be reached, or does not allow actions. Those
+// that already have errors are skipped.
+func (c *HAProxyCtlConfig) checkStatsPages(known configProblems, timeout time.Duration) configProblems {
+	skip := make(map[string]bool)
+	for _, p := range known.errors() {
+		skip[p.LB] = true
+	}
+
+	lbs := c.loadBalancers()
+	results := make([]configProblems, len(lbs))
+	var wg sync.WaitGroup
+	for i := range lbs {
+		lb := &lbs[i]
+		if skip[lb.Name] {
+			continue
+		}
+		//The check's requests give up after the timeout too, so that it does not carry on in the background once
+		//the load balancer is reported, and done has room for its result so that it can always finish
+		check := *lb
+		check.HAProxyCtl = haproxyctl.HAProxyConfig{
+			URL:      lb.HAProxyCtl.URL,
+			Username: lb.HAProxyCtl.Username,
+			Password: lb.HAProxyCtl.Password,
+			Timeout:  timeout,
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			done := make(chan configProblems, 1)
+			go func() { done <- check.checkStatsPage() }()
+			select {
+			case results[i] = <-done:
+			case <-time.After(timeout):
+				results[i] = configProblems{lb.problem(checkUnreachable, "Url", fmt.Errorf("no response within %v", timeout))}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var problems configProblems
+	for _, r := range results {
+		problems = append(problems, r...)
+	}
+	return problems
+}
+
+// checkStatsPage checks that a load balancer's stats can be read, and that it allows actions
+func (lb *LoadBalancer) checkStatsPage() configProblems {
+	if _, err := lb.HAProxyCtl.GetStats(); err != nil {
+		return configProblems{lb.problem(checkUnreachable, "Url", fmt.Errorf("cannot get statistics: %v", err))}
+	}
+	admin, err := lb.HAProxyCtl.IsAdmin()
+	if err != nil {
+		return configProblems{lb.problem(checkUnreachable, "Url", fmt.Errorf("cannot get the stats page: %v", err))}
+	}
+	if !admin {
+		return configProblems{{Severity: severityWarning, Check: checkNotAdmin, LB: lb.Name, Field: "Url", Message: "the stats page is read-only, so actions cannot be sent (it needs stats admin in HAProxy's configuration)"}}
+	}
+	return nil
+}